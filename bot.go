@@ -2,9 +2,16 @@ package botty
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -16,6 +23,36 @@ type (
 	MessageId int64
 )
 
+func (id UserId) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+func (id ChatId) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+func (id MessageId) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+// ParseUserId parses a user ID, e.g. typed by an admin
+func ParseUserId(value string) (UserId, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user id %q: %w", value, err)
+	}
+	return UserId(id), nil
+}
+
+// ParseChatId parses a chat ID. Note that IDs of groups are negative.
+func ParseChatId(value string) (ChatId, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chat id %q: %w", value, err)
+	}
+	return ChatId(id), nil
+}
+
 type TGApi interface {
 	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
 	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
@@ -24,18 +61,95 @@ type TGApi interface {
 	StopReceivingUpdates()
 }
 
+// debugApi logs all requests and responses, see Config.DebugAPI
+type debugApi struct {
+	TGApi
+}
+
+func (d *debugApi) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	log.Printf("api request: %#v", c)
+	resp, err := d.TGApi.Request(c)
+	if resp != nil {
+		log.Printf("api response: %s (err: %v)", resp.Result, err)
+	} else {
+		log.Printf("api response: none (err: %v)", err)
+	}
+	return resp, err
+}
+
+func (d *debugApi) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	log.Printf("api send: %#v", c)
+	msg, err := d.TGApi.Send(c)
+	log.Printf("api sent: message %d (err: %v)", msg.MessageID, err)
+	return msg, err
+}
+
+func (d *debugApi) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	requester, ok := d.TGApi.(rawRequester)
+	if !ok {
+		return nil, fmt.Errorf("api does not support raw requests")
+	}
+	log.Printf("api request %s: %#v", endpoint, params)
+	resp, err := requester.MakeRequest(endpoint, params)
+	if resp != nil {
+		log.Printf("api response: %s (err: %v)", resp.Result, err)
+	} else {
+		log.Printf("api response: none (err: %v)", err)
+	}
+	return resp, err
+}
+
 type Bot[T any] struct {
 	botApi TGApi
 
 	config *Config[T]
 
-	acceptNewUser bool
+	// unix nanos until which new users are accepted
+	acceptUsersUntil atomic.Int64
+
+	// serializes checking and adding users, if the UserManager does not implement UserAdder
+	mUsers sync.Mutex
 
 	mSessions sync.Mutex
 	sessions  map[ChatId]*session[T]
+	// session per user, preferring the private chat if the user has multiple sessions
+	userSessions map[UserId]*session[T]
 
 	startTime time.Time
 
+	// counters for BotStats
+	messagesSent     atomic.Int64
+	messagesReceived atomic.Int64
+
+	// polls sent by the sessions, to route the answers to the session
+	mPolls sync.Mutex
+	polls  map[string]sentPoll
+
+	// consecutive failures storing the sessions and when to retry, see handleStoreResult
+	mStore        sync.Mutex
+	storeFailures int
+	storeRetryAt  time.Time
+
+	// web app data by update ID, see pollUpdates
+	mWebAppData sync.Mutex
+	webAppData  map[int]WebAppData
+
+	// functions to be executed on the loop handling the updates, see enqueue
+	mActions sync.Mutex
+	actions  []func()
+	// signals the loop that actions were enqueued
+	actionsQueued chan struct{}
+	// set while the loop is running
+	running atomic.Bool
+	// closed when Run returned
+	stopped chan struct{}
+
+	// texts passed to the config's OnOutgoing hook by a single worker, see notifyOutgoing
+	outgoing chan outgoingText
+
+	// called after each update was handled, used by the mock to wait for updates
+	updateHandled func(updateID int)
+
 	// will be closed when bot is shutting down
 	shutdown chan struct{}
 }
@@ -50,31 +164,94 @@ func New[T any](config *Config[T]) (*Bot[T], error) {
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to bot api: %w", err)
 	}
+	if api, ok := botApi.(*tgbotapi.BotAPI); ok && config.UpdateBuffer > 0 {
+		api.Buffer = config.UpdateBuffer
+	}
+	if config.DebugAPI {
+		botApi = &debugApi{TGApi: botApi}
+	}
 
-	return &Bot[T]{
-		config:   config,
-		botApi:   botApi,
-		sessions: make(map[ChatId]*session[T]),
-		shutdown: make(chan struct{}),
-	}, nil
+	bot := &Bot[T]{
+		config:        config,
+		botApi:        botApi,
+		sessions:      make(map[ChatId]*session[T]),
+		userSessions:  make(map[UserId]*session[T]),
+		polls:         make(map[string]sentPoll),
+		webAppData:    make(map[int]WebAppData),
+		actionsQueued: make(chan struct{}, 1),
+		stopped:       make(chan struct{}),
+		shutdown:      make(chan struct{}),
+	}
+	if config.OnOutgoing != nil {
+		bot.outgoing = make(chan outgoingText, outgoingBuffer)
+		go bot.runOutgoing()
+	}
+	return bot, nil
 }
 
 func (b *Bot[T]) getOrCreateSession(ctx context.Context, userId UserId, chatId ChatId) (*session[T], error) {
+	session, created := b.getOrAddSession(ctx, userId, chatId)
+	if created {
+		b.activateRootState(session)
+	}
+	return session, nil
+}
+
+// getOrAddSession returns the chat's session, adding a new one if there's none yet.
+// New sessions have to be activated using activateRootState.
+func (b *Bot[T]) getOrAddSession(ctx context.Context, userId UserId, chatId ChatId) (*session[T], bool) {
 	b.mSessions.Lock()
 	defer b.mSessions.Unlock()
 
-	session := b.sessions[chatId]
-	if session == nil {
-		session = NewSession(userId, chatId, b.config.AppStateManager.CreateAppState(userId, chatId), b, ctx, b.botApi)
-		b.sessions[chatId] = session
+	if session := b.sessions[chatId]; session != nil {
+		return session, false
+	}
+	session := NewSession(userId, chatId, b.config.AppStateManager.CreateAppState(userId, chatId), b, ctx, b.botApi)
+	b.addSession(session)
+	return session, true
+}
 
-		// create an initial state and activate
-		session.getOrPushCurrentState()
-		session.CurrentState().Activate(session)
+// activateRootState creates an initial state and activates it. This is done without the
+// sessions lock, as the state's handlers might access the sessions too.
+func (b *Bot[T]) activateRootState(session *session[T]) {
+	session.getOrPushCurrentState()
+	session.CurrentState().Activate(session)
+}
 
+// addSession registers the session. Must be called with the sessions lock held.
+func (b *Bot[T]) addSession(session *session[T]) {
+	b.sessions[session.chatId] = session
+	if existing := b.userSessions[session.userId]; existing == nil || ChatId(session.userId) == session.chatId {
+		b.userSessions[session.userId] = session
 	}
+}
 
-	return session, nil
+// addUserIfNotExists checks and adds the user atomically, using the UserManager's
+// UserAdder implementation if available.
+func (b *Bot[T]) addUserIfNotExists(userId UserId, name string) (bool, error) {
+	if adder, ok := b.config.UserManager.(UserAdder); ok {
+		return adder.AddUserIfNotExists(userId, name)
+	}
+
+	b.mUsers.Lock()
+	defer b.mUsers.Unlock()
+	if b.config.UserManager.UserExists(userId) {
+		return false, nil
+	}
+	return true, b.config.UserManager.AddUser(userId, name)
+}
+
+// SessionForUser returns the session of the user. If the user has multiple sessions,
+// e.g. in groups, their private chat's session is preferred.
+func (b *Bot[T]) SessionForUser(userId UserId) (Session[T], bool) {
+	b.mSessions.Lock()
+	defer b.mSessions.Unlock()
+
+	session, ok := b.userSessions[userId]
+	if !ok {
+		return nil, false
+	}
+	return session, true
 }
 
 var (
@@ -98,47 +275,123 @@ var (
 		Command:     "users",
 		Description: "Goes to the user management",
 	}
+	CommandStatus = tgbotapi.BotCommand{
+		Command:     "status",
+		Description: "Shows the bot's status",
+	}
 )
 
+// botCommands returns the commands for the bot's command menu, i.e. the config's CommandHandlers,
+// the commands the bot handles itself and the config's Commands. Each command is listed once.
+func (b *Bot[T]) botCommands() []tgbotapi.BotCommand {
+	var commands []tgbotapi.BotCommand
+	add := func(command tgbotapi.BotCommand) {
+		if !slices.ContainsFunc(commands, func(c tgbotapi.BotCommand) bool { return c.Command == command.Command }) {
+			commands = append(commands, command)
+		}
+	}
+
+	// registered first, as they take precedence over the built-in commands
+	for _, handler := range b.config.CommandHandlers {
+		add(tgbotapi.BotCommand{Command: handler.Command, Description: handler.Description})
+	}
+	add(CommandMain)
+	add(CommandUsers)
+	add(CommandCancel)
+	add(CommandHelp)
+	add(CommandReload)
+	if b.config.EnableStatusCommand {
+		add(CommandStatus)
+	}
+	for _, command := range b.config.Commands {
+		add(command)
+	}
+	return commands
+}
+
+// BotStats are runtime statistics of the bot, see Bot.Stats
+type BotStats struct {
+	Uptime           time.Duration
+	Sessions         int
+	MessagesSent     int64
+	MessagesReceived int64
+}
+
+func (b *Bot[T]) Stats() BotStats {
+	b.mSessions.Lock()
+	numSessions := len(b.sessions)
+	b.mSessions.Unlock()
+
+	return BotStats{
+		Uptime:           time.Since(b.startTime),
+		Sessions:         numSessions,
+		MessagesSent:     b.messagesSent.Load(),
+		MessagesReceived: b.messagesReceived.Load(),
+	}
+}
+
 func (b *Bot[T]) Run(ctx context.Context) error {
 	b.startTime = time.Now()
-	b.shutdown = make(chan struct{})
+	b.running.Store(true)
+	defer func() {
+		b.running.Store(false)
+		close(b.stopped)
+	}()
 
 	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	u.Timeout = b.config.PollTimeout
+	if u.Timeout <= 0 {
+		u.Timeout = defaultPollTimeout
+	}
+	u.AllowedUpdates = b.config.AllowedUpdates
+	if len(u.AllowedUpdates) == 0 {
+		u.AllowedUpdates = DefaultAllowedUpdates
+	}
 
-	updates := b.botApi.GetUpdatesChan(u)
+	var updates tgbotapi.UpdatesChannel
+	if requester, ok := b.botApi.(rawRequester); ok && b.config.WebAppDataHandler != nil {
+		// the library drops the web app data, so the updates are polled manually
+		updates = b.pollUpdates(ctx, requester, u)
+	} else {
+		updates = b.botApi.GetUpdatesChan(u)
+	}
 
 	// stop the updates
 	defer b.botApi.StopReceivingUpdates()
 
-	_, err := b.botApi.Request(tgbotapi.NewSetMyCommands(
-		CommandMain,
-		CommandUsers,
-		CommandCancel,
-		// CommandHelp,
-		CommandReload))
-	if err != nil {
-		log.Printf("error setting my commands")
+	if !b.config.DisableCommandMenu {
+		_, err := b.botApi.Request(tgbotapi.NewSetMyCommands(b.botCommands()...))
+		if err != nil {
+			log.Printf("error setting my commands: %v", err)
+		}
 	}
 
-	b.loadSessions(ctx)
+	staleKeyboards, err := b.loadSessions(ctx)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	go b.removeStaleKeyboards(ctx, staleKeyboards)
 
-	// broadcast shutdown message and store everything
+	// broadcast shutdown message and store everything. The loop has stopped, so the sessions
+	// are accessed directly.
 	defer func() {
-		for _, session := range b.sessions {
+		b.mSessions.Lock()
+		sessions := slices.Collect(maps.Values(b.sessions))
+		b.mSessions.Unlock()
+
+		for _, session := range sessions {
 			session.Shutdown()
 		}
-		b.ForeachSessionAsync(func(session Session[T]) {
-			if session.LastUserAction().IsZero() {
-				return
+		for _, session := range sessions {
+			if session.blocked || session.lastUserAction.IsZero() {
+				continue
 			}
 			session.SendMessage("Bot is restarting for maintenance. See you in a few minutes. 🧘")
-		})
+		}
 		b.storeSessions(ctx)
 	}()
 
-	sessionStoreTicker := time.NewTicker(60 * time.Second)
+	sessionStoreTicker := time.NewTicker(b.storeInterval())
 	defer sessionStoreTicker.Stop()
 
 	for {
@@ -147,80 +400,513 @@ func (b *Bot[T]) Run(ctx context.Context) error {
 			if !ok {
 				return nil
 			}
-
-			// an update-ID < 0 cannot happen, but it's used by the mock to achieve
-			// synchronous behavior. We will drop it here.
-			if upd.UpdateID < 0 {
-				continue
+			b.processUpdate(ctx, upd)
+			if b.updateHandled != nil {
+				b.updateHandled(upd.UpdateID)
 			}
-
-			user := upd.SentFrom()
-			if user == nil {
-				log.Printf("no sending user - dropping update: %v", upd)
-				continue
+		case <-ctx.Done():
+			return nil
+		case <-b.shutdown:
+			log.Printf("bot shutdown initiated")
+			return nil
+		case <-b.actionsQueued:
+			b.runActions()
+		case <-sessionStoreTicker.C:
+			if b.storeDue() {
+				b.storeSessions(ctx)
 			}
-			if !b.config.UserManager.UserExists(UserId(user.ID)) {
-				if !b.acceptNewUser {
-					log.Printf("user not allowed: %v", user.ID)
-					continue
-				}
+		}
+	}
+}
 
-				name := findNameForUser(user)
-				log.Printf("Adding new user with %d (%s)", user.ID, name)
-				if err := b.config.UserManager.AddUser(UserId(user.ID), name); err != nil {
-					log.Printf("Error adding user: %#v: %v", user, err)
-					continue
-				}
-			}
+// processUpdate handles an update received from telegram. Panics in any handler are recovered,
+// so the bot keeps handling the following updates.
+func (b *Bot[T]) processUpdate(ctx context.Context, upd tgbotapi.Update) {
+	var session *session[T]
+	defer func() {
+		if r := recover(); r != nil {
+			b.handlePanic(session, r)
+		}
+	}()
 
-			session, err := b.getOrCreateSession(ctx, UserId(user.ID), ChatId(upd.FromChat().ID))
-			if err != nil {
-				log.Printf("error handling update %#v: %v", upd, err)
-				continue
+	// an update-ID < 0 cannot happen, but it's used by the mock to achieve
+	// synchronous behavior. We will drop it here.
+	if upd.UpdateID < 0 {
+		return
+	}
+
+	// taken first, so the data is forgotten if the update is dropped
+	webAppData, hasWebAppData := b.takeWebAppData(upd.UpdateID)
+
+	// message dates have second precision, so messages sent within the second of the start are kept
+	if b.config.DropStaleUpdates && upd.Message != nil && upd.Message.Time().Before(b.startTime.Truncate(time.Second)) {
+		log.Printf("dropping stale message %d from %s", upd.Message.MessageID, upd.Message.Time())
+		return
+	}
+
+	if upd.MyChatMember != nil {
+		b.handleMembershipChange(upd.MyChatMember)
+		return
+	}
+
+	if upd.PollAnswer != nil {
+		b.handlePollAnswer(upd.PollAnswer)
+		return
+	}
+
+	// telegram only sends updates of polls sent by the bot
+	if upd.Poll != nil {
+		if upd.Poll.IsClosed {
+			b.unregisterPoll(upd.Poll.ID)
+		}
+		return
+	}
+
+	// inline queries are not bound to a chat, so they are handled without session. Anyone may
+	// query the bot inline, so they don't add users either.
+	if upd.InlineQuery != nil {
+		b.handleInlineQuery(ctx, upd.InlineQuery)
+		return
+	}
+
+	user := upd.SentFrom()
+	if user == nil {
+		log.Printf("no sending user - dropping update: %v", upd)
+		return
+	}
+	var newUser bool
+	if !b.config.UserManager.UserExists(UserId(user.ID)) {
+		if !b.AcceptingUsers() {
+			log.Printf("user not allowed: %v", user.ID)
+			if b.config.OnUnauthorized != nil {
+				b.config.OnUnauthorized(b.botApi, upd)
 			}
+			return
+		}
 
-			if !session.Handle(upd) {
-				if upd.Message != nil && upd.Message.Command() != "" {
-					command := upd.Message.Command()
-					switch command {
-					case CommandCancel.Command:
-						session.PopState()
-					case CommandReload.Command:
-						session.ReplaceState(session.CurrentState())
-					case CommandHelp.Command:
-						session.SendMessage("Help message how to use the bot. TODO.")
-					case CommandMain.Command:
-						session.ResetToState(b.rootState())
-					case CommandUsers.Command:
-						session.ResetToState(UsersList[T](b.config.UserManager))
-					default:
-						log.Printf("unhandled command: %s", command)
-					}
-				} else {
-					log.Printf("unhandled update: %#v", upd)
+		name := findNameForUser(user)
+		added, err := b.addUserIfNotExists(UserId(user.ID), name)
+		if err != nil {
+			log.Printf("Error adding user: %#v: %v", user, err)
+			return
+		}
+		if added {
+			log.Printf("Added new user with %d (%s)", user.ID, name)
+		}
+		newUser = added
+	}
+
+	chatId, ok := updateChatId(upd)
+	if !ok {
+		log.Printf("no chat - dropping update: %v", upd)
+		return
+	}
+
+	// assigned to the outer session, so a panic can be reported to the user
+	var created bool
+	session, created = b.getOrAddSession(ctx, UserId(user.ID), chatId)
+	if created {
+		b.activateRootState(session)
+	}
+
+	if upd.Message != nil {
+		b.messagesReceived.Add(1)
+	}
+
+	if newUser && b.config.OnNewUser != nil {
+		b.config.OnNewUser(session)
+	}
+
+	if hasWebAppData {
+		b.config.WebAppDataHandler(session, webAppData)
+		return
+	}
+
+	if !b.handleUpdate(session, upd) {
+		if upd.Message != nil && upd.Message.Command() != "" {
+			command := upd.Message.Command()
+			switch command {
+			case CommandCancel.Command:
+				session.PopState()
+			case CommandReload.Command:
+				session.Reenter()
+			case CommandHelp.Command:
+				session.sendHelp()
+			case CommandMain.Command:
+				session.ResetToState(b.rootState())
+			case CommandUsers.Command:
+				session.ResetToState(UsersList[T](b.config.UserManager, b.config.UsersListOptions...))
+			case CommandStatus.Command:
+				if !b.config.EnableStatusCommand {
+					log.Printf("unhandled command: %s", command)
+					b.handleUnhandled(session, upd)
+					break
 				}
+				session.PushState(StatusState[T]())
+			default:
+				log.Printf("unhandled command: %s", command)
+				b.handleUnhandled(session, upd)
 			}
-		case <-ctx.Done():
-			return nil
-		case <-b.shutdown:
-			log.Printf("bot shutdown initiated")
-			return nil
-		case <-sessionStoreTicker.C:
-			b.storeSessions(ctx)
+		} else {
+			log.Printf("unhandled update in state %q: %#v", session.CurrentStateName(), upd)
+			b.handleUnhandled(session, upd)
 		}
 	}
 }
 
+// updateChatId returns the chat of the update. Callbacks of inline messages have no chat,
+// so they are routed to the private chat with the user.
+func updateChatId(upd tgbotapi.Update) (ChatId, bool) {
+	if upd.CallbackQuery != nil && upd.CallbackQuery.Message == nil {
+		if upd.CallbackQuery.From == nil {
+			return 0, false
+		}
+		return ChatId(upd.CallbackQuery.From.ID), true
+	}
+	chat := upd.FromChat()
+	if chat == nil {
+		return 0, false
+	}
+	return ChatId(chat.ID), true
+}
+
+// handleUpdate lets the session handle the update, showing the typing action if configured.
+func (b *Bot[T]) handleUpdate(session *session[T], upd tgbotapi.Update) bool {
+	if b.config.AutoTyping {
+		done := make(chan struct{})
+		defer close(done)
+		go b.typeUntil(session, done)
+	}
+
+	return session.Handle(upd)
+}
+
+// handlePanic logs a recovered panic and tells the session's user, if there's a session.
+func (b *Bot[T]) handlePanic(session *session[T], recovered any) {
+	if session == nil {
+		log.Printf("panic handling update: %v\n%s", recovered, debug.Stack())
+		return
+	}
+	log.Printf("panic handling update in state %q: %v\n%s", session.CurrentStateName(), recovered, debug.Stack())
+	if b.config.OnPanic != nil {
+		b.config.OnPanic(session, recovered)
+		return
+	}
+	session.SendMessage("Something went wrong 😵. Please try again.")
+}
+
+// runActions runs the enqueued actions. Actions enqueued meanwhile signal the loop again,
+// so they run in the next iteration.
+func (b *Bot[T]) runActions() {
+	b.mActions.Lock()
+	actions := b.actions
+	b.actions = nil
+	b.mActions.Unlock()
+
+	for _, action := range actions {
+		b.runAction(action)
+	}
+}
+
+// runAction runs an action passed to the loop, recovering from panics
+func (b *Bot[T]) runAction(action func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.handlePanic(nil, r)
+		}
+	}()
+	action()
+}
+
+// interval to repeat the typing action, which telegram shows for 5 seconds
+const typingInterval = 4 * time.Second
+
+// typeUntil shows the typing action in the session's chat after the configured delay until done is closed
+func (b *Bot[T]) typeUntil(session *session[T], done <-chan struct{}) {
+	delay := b.config.AutoTypingDelay
+	if delay <= 0 {
+		delay = defaultAutoTypingDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			session.SendChatAction(tgbotapi.ChatTyping)
+			timer.Reset(typingInterval)
+		}
+	}
+}
+
+func (b *Bot[T]) handleUnhandled(session *session[T], upd tgbotapi.Update) {
+	if b.config.OnUnhandled == nil {
+		return
+	}
+
+	info := UpdateInfo{UpdateID: upd.UpdateID}
+	switch {
+	case upd.Message != nil:
+		info.Message = &tgMessage{m: upd.Message}
+		info.Command = upd.Message.Command()
+	case upd.EditedMessage != nil:
+		info.Message = &tgMessage{m: upd.EditedMessage}
+	case upd.CallbackQuery != nil:
+		info.CallbackQuery = &tgCbQuery{m: upd.CallbackQuery}
+	}
+	b.config.OnUnhandled(session, info)
+}
+
+func (b *Bot[T]) handleMembershipChange(update *tgbotapi.ChatMemberUpdated) {
+	change := MembershipChange{
+		UserID:    UserId(update.From.ID),
+		ChatID:    ChatId(update.Chat.ID),
+		OldStatus: MemberStatus(update.OldChatMember.Status),
+		NewStatus: MemberStatus(update.NewChatMember.Status),
+	}
+
+	b.mSessions.Lock()
+	session := b.sessions[change.ChatID]
+	b.mSessions.Unlock()
+	if session != nil {
+		session.blocked = change.NewStatus == MemberStatusKicked || change.NewStatus == MemberStatusLeft
+	}
+
+	if b.config.OnMembershipChange != nil {
+		b.config.OnMembershipChange(change)
+	}
+}
+
+func (b *Bot[T]) handleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery) {
+	if b.config.InlineQueryHandler == nil {
+		return
+	}
+
+	results := b.config.InlineQueryHandler(ctx, InlineQuery{
+		ID:     query.ID,
+		From:   UserId(query.From.ID),
+		Query:  query.Query,
+		Offset: query.Offset,
+	})
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		CacheTime:     b.config.InlineQueryCacheTime,
+		IsPersonal:    b.config.InlineQueryPersonal,
+		Results:       make([]interface{}, 0, len(results)),
+	}
+	for _, result := range results {
+		article := tgbotapi.NewInlineQueryResultArticleHTML(result.ID, result.Title, result.Text)
+		article.Description = result.Description
+		answer.Results = append(answer.Results, article)
+	}
+
+	if _, err := b.botApi.Request(answer); err != nil {
+		log.Printf("error answering inline query: %v", err)
+	}
+}
+
+type sentPoll struct {
+	chatId ChatId
+	sent   time.Time
+}
+
+const (
+	// polls are forgotten when closed, but users rarely close them, so open polls are
+	// limited and expire eventually
+	maxTrackedPolls = 1000
+	pollExpiry      = 30 * 24 * time.Hour
+)
+
+func (b *Bot[T]) registerPoll(pollId string, chatId ChatId) {
+	b.mPolls.Lock()
+	defer b.mPolls.Unlock()
+
+	now := time.Now()
+	var oldestId string
+	for id, poll := range b.polls {
+		if now.Sub(poll.sent) > pollExpiry {
+			delete(b.polls, id)
+			continue
+		}
+		if oldestId == "" || poll.sent.Before(b.polls[oldestId].sent) {
+			oldestId = id
+		}
+	}
+	if len(b.polls) >= maxTrackedPolls {
+		delete(b.polls, oldestId)
+	}
+	b.polls[pollId] = sentPoll{chatId: chatId, sent: now}
+}
+
+func (b *Bot[T]) unregisterPoll(pollId string) {
+	b.mPolls.Lock()
+	defer b.mPolls.Unlock()
+	delete(b.polls, pollId)
+}
+
+// unregisterChatPolls forgets the polls sent to the chat, e.g. when its session is deleted
+func (b *Bot[T]) unregisterChatPolls(chatId ChatId) {
+	b.mPolls.Lock()
+	defer b.mPolls.Unlock()
+	for id, poll := range b.polls {
+		if poll.chatId == chatId {
+			delete(b.polls, id)
+		}
+	}
+}
+
+func (b *Bot[T]) handlePollAnswer(answer *tgbotapi.PollAnswer) {
+	if b.config.PollAnswerHandler == nil {
+		return
+	}
+
+	b.mPolls.Lock()
+	poll, ok := b.polls[answer.PollID]
+	b.mPolls.Unlock()
+	if !ok {
+		log.Printf("answer for unknown poll %s - dropping", answer.PollID)
+		return
+	}
+	chatId := poll.chatId
+
+	b.mSessions.Lock()
+	session := b.sessions[chatId]
+	b.mSessions.Unlock()
+	if session == nil {
+		log.Printf("no session for poll %s in chat %d - dropping answer", answer.PollID, chatId)
+		return
+	}
+
+	b.config.PollAnswerHandler(session, PollAnswer{
+		PollID:    answer.PollID,
+		UserID:    UserId(answer.User.ID),
+		OptionIDs: answer.OptionIDs,
+	})
+}
+
+// Do executes fn with the chat's session on the loop that handles the updates, e.g. to advance
+// a session's state on an external event. Sessions are not safe for concurrent use, so this is the
+// only safe way to modify a session from another goroutine. Do returns once fn is enqueued,
+// so it may be called from a handler as well, fn then runs after the handler returned.
+// fn is not called if the chat has no session or the bot stops before running it.
+func (b *Bot[T]) Do(chatId ChatId, fn func(bs Session[T])) {
+	action := func() {
+		b.mSessions.Lock()
+		session := b.sessions[chatId]
+		b.mSessions.Unlock()
+
+		if session == nil {
+			log.Printf("no session for chat %d - dropping action", chatId)
+			return
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				b.handlePanic(session, r)
+			}
+		}()
+		fn(session)
+	}
+	b.enqueue(action)
+}
+
 func (b *Bot[T]) rootState() State[T] {
 	return b.config.RootState()
 }
 
+// ForeachSessionAsync calls do for every session, except the ones that blocked the bot, each in its
+// own goroutine. do runs concurrently to the update loop, so it may send messages, but must not modify
+// the session. Use Do to modify sessions, or SessionsSnapshot to read their data.
 func (b *Bot[T]) ForeachSessionAsync(do func(session Session[T])) {
-	for _, session := range b.sessions {
-		session := session
-		go func() {
-			do(session)
-		}()
+	b.mSessions.Lock()
+	sessions := slices.Collect(maps.Values(b.sessions))
+	b.mSessions.Unlock()
+
+	for _, session := range sessions {
+		if session.blocked {
+			continue
+		}
+		go do(session)
+	}
+}
+
+// SessionSnapshot is a read-only copy of a session's data, safe to use from other goroutines.
+// State is a copy of the app state, which is shallow: if T is a pointer or contains maps or slices,
+// the referenced data is shared with the live session and must not be modified.
+type SessionSnapshot[T any] struct {
+	UserID         UserId
+	ChatID         ChatId
+	LastUserAction time.Time
+	Blocked        bool
+	// name of the current state, see StateBuilder.Named
+	StateName string
+	State     T
+}
+
+// SessionsSnapshot returns snapshots of all sessions, e.g. for statistics or broadcasts
+// that should not touch the live sessions. The snapshots are taken on the update loop,
+// so it waits for the update being handled, if any. It must not be called from a handler.
+func (b *Bot[T]) SessionsSnapshot() []SessionSnapshot[T] {
+	var snapshots []SessionSnapshot[T]
+	b.runOnLoop(func() {
+		b.mSessions.Lock()
+		defer b.mSessions.Unlock()
+
+		snapshots = make([]SessionSnapshot[T], 0, len(b.sessions))
+		for _, session := range b.sessions {
+			snapshots = append(snapshots, SessionSnapshot[T]{
+				UserID:         session.userId,
+				ChatID:         session.chatId,
+				LastUserAction: session.lastUserAction,
+				Blocked:        session.blocked,
+				StateName:      session.CurrentStateName(),
+				State:          session.appState,
+			})
+		}
+	})
+	return snapshots
+}
+
+// enqueue queues fn to be run on the loop handling the updates. It does not block,
+// so it may be called from handlers as well.
+func (b *Bot[T]) enqueue(fn func()) {
+	b.mActions.Lock()
+	b.actions = append(b.actions, fn)
+	b.mActions.Unlock()
+
+	select {
+	case b.actionsQueued <- struct{}{}:
+	default:
+		// the loop has been signaled already
+	}
+}
+
+// runOnLoop runs fn on the loop handling the updates and waits until it's done. fn is run directly
+// if the bot is not running, as nothing modifies the sessions then. It must not be called from a handler,
+// which would wait for itself.
+func (b *Bot[T]) runOnLoop(fn func()) {
+	if !b.running.Load() {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	b.enqueue(func() {
+		defer close(done)
+		fn()
+	})
+
+	select {
+	case <-done:
+	case <-b.stopped:
+		// the loop might have stopped before running fn
+		select {
+		case <-done:
+		default:
+			fn()
+		}
 	}
 }
 
@@ -228,42 +914,176 @@ func (b *Bot[T]) shutdownBot() {
 	close(b.shutdown)
 }
 
+// AcceptUsers lets unknown users join the bot for the given duration
 func (b *Bot[T]) AcceptUsers(dur time.Duration) {
-	b.acceptNewUser = true
-	go func() {
-		select {
-		case <-time.After(dur):
-			b.acceptNewUser = false
-		case <-b.shutdown:
+	b.acceptUsersUntil.Store(time.Now().Add(dur).UnixNano())
+}
+
+// AcceptingUsers returns whether unknown users are currently allowed to join
+func (b *Bot[T]) AcceptingUsers() bool {
+	return time.Now().UnixNano() < b.acceptUsersUntil.Load()
+}
+
+// StopAccepting stops accepting new users before the duration passed to AcceptUsers is over
+func (b *Bot[T]) StopAccepting() {
+	b.acceptUsersUntil.Store(0)
+}
+
+// DeleteUser deletes the user from the UserManager and removes their sessions, including the stored ones.
+func (b *Bot[T]) DeleteUser(userId UserId) error {
+	if err := b.config.UserManager.DeleteUser(userId); err != nil {
+		return fmt.Errorf("error deleting user %d: %w", userId, err)
+	}
+
+	b.mSessions.Lock()
+	var removed []*session[T]
+	for chatId, session := range b.sessions {
+		if session.userId == userId {
+			removed = append(removed, session)
+			delete(b.sessions, chatId)
 		}
-	}()
+	}
+	delete(b.userSessions, userId)
+	b.mSessions.Unlock()
+
+	for _, session := range removed {
+		session.Shutdown()
+		b.unregisterChatPolls(session.chatId)
+		if err := b.config.AppStateManager.DeleteSessionState(session.userId, session.chatId); err != nil {
+			return fmt.Errorf("error deleting session of user %d: %w", userId, err)
+		}
+	}
+	return nil
+}
+
+// StoreNow stores all sessions immediately, independent of the store interval.
+// The sessions are stored on the update loop and StoreNow blocks until they're stored,
+// so it must not be called from a handler.
+func (b *Bot[T]) StoreNow(ctx context.Context) {
+	b.runOnLoop(func() {
+		b.storeSessions(ctx)
+	})
 }
 
 func (b *Bot[T]) storeSessions(ctx context.Context) {
+	// collect the states under lock but store them without, as storing might be slow
 	b.mSessions.Lock()
-	defer b.mSessions.Unlock()
+	states := make([]StoredSessionState[T], 0, len(b.sessions))
 	for _, session := range b.sessions {
-		err := b.config.AppStateManager.StoreSessionState(StoredSessionState[T]{
-			UserID:     UserId(session.userId),
-			ChatID:     ChatId(session.chatId),
-			LastAction: time.Now(),
-			State:      session.appState,
+		states = append(states, StoredSessionState[T]{
+			UserID:         UserId(session.userId),
+			ChatID:         ChatId(session.chatId),
+			LastAction:     time.Now(),
+			Settings:       session.settings,
+			InlineMessages: slices.Clone(session.inlineMessages),
+			State:          session.appState,
 		})
-		if err != nil {
-			log.Printf("error storing session for user %d: %v", session.userId, err)
+	}
+	b.mSessions.Unlock()
+
+	if batchStorer, ok := b.config.AppStateManager.(BatchSessionStorer[T]); ok {
+		b.handleStoreResult(batchStorer.StoreSessionStates(states))
+		return
+	}
+
+	var errs []error
+	for _, state := range states {
+		if err := b.config.AppStateManager.StoreSessionState(state); err != nil {
+			errs = append(errs, fmt.Errorf("error storing session for user %d: %w", state.UserID, err))
+		}
+	}
+	b.handleStoreResult(errors.Join(errs...))
+}
+
+// handleStoreResult tracks consecutive store failures to back off from a failing backend.
+// Instead of logging each failed session, one line is logged per store attempt.
+func (b *Bot[T]) handleStoreResult(err error) {
+	b.mStore.Lock()
+	defer b.mStore.Unlock()
+
+	if err == nil {
+		if b.storeFailures > 0 {
+			log.Printf("storing sessions succeeded again after %d failures", b.storeFailures)
 		}
+		b.storeFailures = 0
+		b.storeRetryAt = time.Time{}
+		return
 	}
+
+	b.storeFailures++
+	// permanent errors back off as well, as retrying them every interval only repeats the failure
+	storeErr := StoreError{
+		Err:       err,
+		Failures:  b.storeFailures,
+		Permanent: isPermanentStoreError(err),
+		RetryIn:   storeBackoff(b.storeInterval(), b.storeFailures),
+	}
+	b.storeRetryAt = time.Now().Add(storeErr.RetryIn)
+	log.Printf("error storing sessions (failure %d, retrying in %s): %v", storeErr.Failures, storeErr.RetryIn, firstLine(err))
+
+	if b.config.OnStoreError != nil {
+		b.config.OnStoreError(storeErr)
+	}
+}
+
+// storeDue returns false while backing off after failed stores
+func (b *Bot[T]) storeDue() bool {
+	b.mStore.Lock()
+	defer b.mStore.Unlock()
+	return !time.Now().Before(b.storeRetryAt)
 }
 
-func (b *Bot[T]) loadSessions(ctx context.Context) error {
+func (b *Bot[T]) storeInterval() time.Duration {
+	if b.config.StoreInterval <= 0 {
+		return defaultStoreInterval
+	}
+	return b.config.StoreInterval
+}
+
+// storeBackoff doubles the interval for each consecutive failure, up to maxStoreBackoff
+func storeBackoff(interval time.Duration, failures int) time.Duration {
+	backoff := interval
+	for i := 1; i < failures && backoff < maxStoreBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxStoreBackoff)
+}
+
+// isPermanentStoreError returns true if all joined errors are permanent
+func isPermanentStoreError(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !errors.Is(err, ErrStorePermanent) {
+				return false
+			}
+		}
+		return true
+	}
+	return errors.Is(err, ErrStorePermanent)
+}
+
+// firstLine shortens joined errors to the first one and the number of others
+func firstLine(err error) string {
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) == 1 {
+		return lines[0]
+	}
+	return fmt.Sprintf("%s (and %d more)", lines[0], len(lines)-1)
+}
+
+// loadSessions loads the stored sessions and returns the inline keyboards of messages
+// sent before the restart, which have to be removed.
+func (b *Bot[T]) loadSessions(ctx context.Context) ([]staleKeyboard, error) {
 	b.mSessions.Lock()
 	defer b.mSessions.Unlock()
 
 	sessions, err := b.config.AppStateManager.LoadSessionStates()
 	if err != nil {
-		return fmt.Errorf("error loading sessions: %v", err)
+		return nil, fmt.Errorf("error loading sessions: %v", err)
 	}
 
+	var staleKeyboards []staleKeyboard
+
 	for _, session := range sessions {
 
 		if session.ChatID == 0 || session.UserID == 0 {
@@ -272,7 +1092,16 @@ func (b *Bot[T]) loadSessions(ctx context.Context) error {
 		}
 
 		bs := NewSession(UserId(session.UserID), ChatId(session.ChatID), session.State, b, ctx, b.botApi)
-		b.sessions[session.ChatID] = bs
+		bs.settings = session.Settings
+		if err := bs.loadTimeZone(); err != nil {
+			log.Printf("%v, using local time", err)
+		}
+		b.addSession(bs)
+
+		// the buttons of messages sent before the restart are not handled anymore
+		for _, messageId := range session.InlineMessages {
+			staleKeyboards = append(staleKeyboards, staleKeyboard{chatId: bs.chatId, messageId: messageId})
+		}
 
 		// if the user was active in the last 30 days, we'll tell them that the bot is back by activating the current state
 		if !session.LastAction.IsZero() && time.Since(session.LastAction) < time.Hour*24*30 {
@@ -285,5 +1114,42 @@ func (b *Bot[T]) loadSessions(ctx context.Context) error {
 
 	}
 
-	return nil
+	return staleKeyboards, nil
+}
+
+// staleKeyboard is the inline keyboard of a message sent before the bot restarted
+type staleKeyboard struct {
+	chatId    ChatId
+	messageId MessageId
+}
+
+// interval between removing stale keyboards, to stay below telegram's rate limits
+const staleKeyboardInterval = 100 * time.Millisecond
+
+// removeStaleKeyboards removes the keyboards one by one, so a restart with many sessions
+// neither delays handling updates nor hits the rate limits.
+func (b *Bot[T]) removeStaleKeyboards(ctx context.Context, keyboards []staleKeyboard) {
+	ticker := time.NewTicker(staleKeyboardInterval)
+	defer ticker.Stop()
+
+	for _, keyboard := range keyboards {
+		// set the ReplyMarkup to nil manually, see RemoveKeyboardForMessage. Errors are ignored,
+		// as the message might have been deleted or edited in the meantime.
+		b.botApi.Request(tgbotapi.EditMessageReplyMarkupConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:    int64(keyboard.chatId),
+				MessageID: int(keyboard.messageId),
+			},
+		})
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-b.shutdown:
+			return
+		case <-b.stopped:
+			return
+		}
+	}
 }
@@ -0,0 +1,478 @@
+package botty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestConfig creates a config with in-memory managers and the root state, knowing the users
+func newTestConfig(root func() State[int], users ...UserId) *Config[int] {
+	userManager := NewInMemoryUserManager()
+	for _, user := range users {
+		userManager.AddUser(user, fmt.Sprintf("user %d", user))
+	}
+	return NewConfig[int]("", NewInMemoryAppStateManager[int](), userManager, root)
+}
+
+// newEchoState answers every message with "echo <text>"
+func newEchoState() State[int] {
+	return NewStateBuilder[int]().
+		OnActivate(func(bs Session[int]) {
+			bs.SendMessage("hello")
+		}).
+		OnMessage(func(bs Session[int], msg ChatMessage) {
+			bs.SendMessage("echo " + msg.Text())
+		}).
+		Build()
+}
+
+func newTestMock(t *testing.T, cfg *Config[int]) *MockBot[int] {
+	t.Helper()
+	mock, err := NewMockBot(cfg)
+	if err != nil {
+		t.Fatalf("error creating mock: %v", err)
+	}
+	t.Cleanup(mock.Stop)
+	return mock
+}
+
+func TestPollsArePruned(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState, 1))
+	bot := mock.bot
+	numPolls := func() int {
+		bot.mPolls.Lock()
+		defer bot.mPolls.Unlock()
+		return len(bot.polls)
+	}
+
+	bot.registerPoll("closed", 1)
+	mock.sendUpdate(tgbotapi.Update{Poll: &tgbotapi.Poll{ID: "closed", IsClosed: true}})
+	if n := numPolls(); n != 0 {
+		t.Errorf("closed poll was not removed, %d polls tracked", n)
+	}
+
+	bot.registerPoll("expired", 1)
+	bot.mPolls.Lock()
+	bot.polls["expired"] = sentPoll{chatId: 1, sent: time.Now().Add(-pollExpiry - time.Hour)}
+	bot.mPolls.Unlock()
+	for i := range maxTrackedPolls + 10 {
+		bot.registerPoll(fmt.Sprintf("poll-%d", i), 2)
+	}
+	if n := numPolls(); n != maxTrackedPolls {
+		t.Errorf("expected %d polls, got %d", maxTrackedPolls, n)
+	}
+	bot.mPolls.Lock()
+	_, hasExpired := bot.polls["expired"]
+	_, hasNewest := bot.polls[fmt.Sprintf("poll-%d", maxTrackedPolls+9)]
+	bot.mPolls.Unlock()
+	if hasExpired || !hasNewest {
+		t.Errorf("expected the expired poll to be dropped and the newest to be kept")
+	}
+
+	bot.unregisterChatPolls(2)
+	if n := numPolls(); n != 0 {
+		t.Errorf("polls of the chat were not removed, %d polls tracked", n)
+	}
+}
+
+func TestOnNewUser(t *testing.T) {
+	cfg := newTestConfig(newEchoState, 1)
+	var newUsers []UserId
+	cfg.OnNewUser = func(bs Session[int]) {
+		newUsers = append(newUsers, bs.UserId())
+	}
+	mock := newTestMock(t, cfg)
+
+	// known users are not new
+	mock.SendAndWait(1, "hi")
+	// unknown users are rejected unless accepted
+	mock.SendAndWait(2, "hi")
+	if len(newUsers) != 0 {
+		t.Fatalf("unexpected new users %v", newUsers)
+	}
+
+	// two updates from the same new user add it once
+	mock.bot.AcceptUsers(time.Hour)
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mock.SendAndWait(3, "hi")
+		}()
+	}
+	wg.Wait()
+	mock.SendAndWait(3, "again")
+
+	if !slices.Equal(newUsers, []UserId{3}) {
+		t.Errorf("expected user 3 to be new once, got %v", newUsers)
+	}
+	users, err := cfg.UserManager.ListUsers()
+	if err != nil {
+		t.Fatalf("error listing users: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("expected 2 users, got %v", users)
+	}
+}
+
+func TestInlineQueriesDontAddUsers(t *testing.T) {
+	cfg := newTestConfig(newEchoState, 1)
+	var queries []InlineQuery
+	cfg.InlineQueryHandler = func(ctx context.Context, query InlineQuery) []InlineResult {
+		queries = append(queries, query)
+		return []InlineResult{{ID: "1", Title: "result", Text: "text"}}
+	}
+	var newUsers []UserId
+	cfg.OnNewUser = func(bs Session[int]) {
+		newUsers = append(newUsers, bs.UserId())
+	}
+	mock := newTestMock(t, cfg)
+	mock.bot.AcceptUsers(time.Hour)
+
+	mock.sendUpdate(tgbotapi.Update{
+		InlineQuery: &tgbotapi.InlineQuery{ID: "query", From: &tgbotapi.User{ID: 2}, Query: "foo"},
+	})
+
+	if len(queries) != 1 || queries[0].From != 2 || queries[0].Query != "foo" {
+		t.Fatalf("expected the query of user 2 to be handled, got %v", queries)
+	}
+	if cfg.UserManager.UserExists(2) || len(newUsers) != 0 {
+		t.Errorf("inline query added user 2, new users %v", newUsers)
+	}
+	var answered bool
+	for _, request := range mock.Requests() {
+		if answer, ok := request.(tgbotapi.InlineConfig); ok && answer.InlineQueryID == "query" {
+			answered = true
+		}
+	}
+	if !answered {
+		t.Errorf("inline query was not answered")
+	}
+}
+
+func TestAcceptUsersConcurrently(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState))
+
+	stop := make(chan struct{})
+	toggled := make(chan struct{})
+	go func() {
+		defer close(toggled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				mock.bot.AcceptUsers(time.Hour)
+				mock.bot.AcceptingUsers()
+				mock.bot.StopAccepting()
+			}
+		}
+	}()
+	for i := range 20 {
+		mock.SendAndWait(UserId(i+1), "hi")
+	}
+	close(stop)
+	<-toggled
+
+	mock.bot.StopAccepting()
+	if mock.bot.AcceptingUsers() {
+		t.Errorf("expected the bot to not accept users")
+	}
+	mock.bot.AcceptUsers(time.Hour)
+	if !mock.bot.AcceptingUsers() {
+		t.Errorf("expected the bot to accept users")
+	}
+}
+
+func TestSessionsConcurrentAccess(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState, 1, 2, 3))
+	mock.SendAndWait(1, "hi")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				mock.bot.SessionsSnapshot()
+			}
+		}
+	}()
+	for i := range 20 {
+		mock.SendAndWait(UserId(i%3+1), fmt.Sprintf("message %d", i))
+	}
+	close(stop)
+	<-done
+
+	if n := len(mock.bot.SessionsSnapshot()); n != 3 {
+		t.Errorf("expected 3 sessions, got %d", n)
+	}
+}
+
+func TestStoreNow(t *testing.T) {
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				// modify the state for a while to overlap with concurrent stores
+				for range 10 {
+					bs.(*session[int]).appState = len(msg.Text())
+					time.Sleep(100 * time.Microsecond)
+				}
+			}).
+			Build()
+	}
+	cfg := newTestConfig(root, 1)
+	mock := newTestMock(t, cfg)
+	mock.SendAndWait(1, "hi")
+
+	stop := make(chan struct{})
+	stored := make(chan struct{})
+	go func() {
+		defer close(stored)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				mock.bot.StoreNow(context.Background())
+			}
+		}
+	}()
+	for i := range 20 {
+		mock.SendAndWait(1, strings.Repeat("x", i+1))
+	}
+	close(stop)
+	<-stored
+
+	mock.bot.StoreNow(context.Background())
+	states, err := cfg.AppStateManager.LoadSessionStates()
+	if err != nil {
+		t.Fatalf("error loading sessions: %v", err)
+	}
+	if len(states) != 1 || states[0].State != 20 {
+		t.Errorf("expected the state of the last message to be stored, got %+v", states)
+	}
+}
+
+// failingStateManager fails storing every session with err
+type failingStateManager struct {
+	AppStateManager[int]
+	err error
+}
+
+func (f *failingStateManager) StoreSessionState(state StoredSessionState[int]) error {
+	return f.err
+}
+
+func TestPermanentStoreErrorsBackOff(t *testing.T) {
+	cfg := newTestConfig(newEchoState, 1)
+	cfg.AppStateManager = &failingStateManager{
+		AppStateManager: cfg.AppStateManager,
+		err:             fmt.Errorf("cannot encode state: %w", ErrStorePermanent),
+	}
+	var storeErrs []StoreError
+	cfg.OnStoreError = func(err StoreError) {
+		storeErrs = append(storeErrs, err)
+	}
+	mock := newTestMock(t, cfg)
+	mock.SendAndWait(1, "hi")
+
+	mock.bot.StoreNow(context.Background())
+	if len(storeErrs) != 1 {
+		t.Fatalf("expected one store error, got %v", storeErrs)
+	}
+	storeErr := storeErrs[0]
+	if !storeErr.Permanent || storeErr.RetryIn != mock.bot.storeInterval() || !errors.Is(storeErr, ErrStorePermanent) {
+		t.Errorf("unexpected store error %v (permanent %t, retry in %s)", storeErr, storeErr.Permanent, storeErr.RetryIn)
+	}
+	if mock.bot.storeDue() {
+		t.Errorf("expected to back off after a permanent error")
+	}
+}
+
+func TestMockCreateSessionConcurrently(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState, 1))
+
+	created := make(chan struct{})
+	go func() {
+		defer close(created)
+		for i := range 20 {
+			if _, err := mock.CreateSession(UserId(100 + i)); err != nil {
+				t.Errorf("error creating session: %v", err)
+			}
+		}
+	}()
+	for i := range 20 {
+		mock.SendAndWait(1, fmt.Sprintf("message %d", i))
+	}
+	<-created
+
+	if n := len(mock.bot.SessionsSnapshot()); n != 21 {
+		t.Errorf("expected 21 sessions, got %d", n)
+	}
+}
+
+func TestDoFromHandlerDoesNotBlock(t *testing.T) {
+	var calls []string
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				for range 100 {
+					bs.Do(func(bs Session[int]) { calls = append(calls, "session") })
+				}
+				bs.(*session[int]).bot.Do(bs.ChatId(), func(bs Session[int]) { calls = append(calls, "bot") })
+				calls = append(calls, "handler")
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+
+	done := make(chan struct{})
+	go func() {
+		mock.SendAndWait(1, "hi")
+		// actions run in order, so the handler's actions are done after the snapshot
+		mock.bot.SessionsSnapshot()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler calling Do deadlocked")
+	}
+	if len(calls) != 102 || calls[0] != "handler" || calls[101] != "bot" {
+		t.Errorf("expected the actions to run after the handler, got %d calls starting with %q", len(calls), calls[0])
+	}
+}
+
+func TestDoFromOtherGoroutine(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState, 1))
+	mock.SendAndWait(1, "hi")
+
+	state := make(chan string)
+	go mock.bot.Do(1, func(bs Session[int]) {
+		bs.SendMessage("from outside")
+		state <- bs.CurrentStateName()
+	})
+	<-state
+	if text := mock.LastMessageText(); text != "from outside" {
+		t.Errorf("unexpected last message %q", text)
+	}
+}
+
+func TestPanicsAreRecovered(t *testing.T) {
+	var panicOnActivate bool
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {
+				if panicOnActivate {
+					panic("activate")
+				}
+			}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				if msg.Text() == "panic" {
+					panic("message")
+				}
+				bs.SendMessage("echo " + msg.Text())
+			}).
+			Build()
+	}
+	cfg := newTestConfig(root, 1, 2)
+	var recovered []any
+	cfg.OnPanic = func(bs Session[int], r any) {
+		recovered = append(recovered, r)
+	}
+	cfg.OnNewUser = func(bs Session[int]) {
+		panic("new user")
+	}
+	mock := newTestMock(t, cfg)
+
+	// the root state panics while activating the new session
+	panicOnActivate = true
+	mock.SendAndWait(1, "hi")
+	// panics in the state's handlers and in the reload command
+	mock.SendAndWait(1, "panic")
+	mock.SendCommand(1, "reload")
+	panicOnActivate = false
+
+	// panics in actions
+	done := make(chan struct{})
+	mock.bot.Do(1, func(bs Session[int]) {
+		defer close(done)
+		panic("action")
+	})
+	<-done
+
+	// panics in OnNewUser
+	mock.bot.AcceptUsers(time.Hour)
+	mock.SendAndWait(3, "hi")
+
+	if want := []any{"activate", "message", "activate", "action", "new user"}; !slices.Equal(recovered, want) {
+		t.Errorf("expected panics %v, got %v", want, recovered)
+	}
+
+	// the bot keeps handling updates
+	mock.SendAndWait(1, "still there?")
+	if text := mock.LastMessageText(); text != "echo still there?" {
+		t.Errorf("unexpected last message %q", text)
+	}
+	mock.SendAndWait(2, "me too?")
+	if text := mock.LastMessageText(); text != "echo me too?" {
+		t.Errorf("unexpected last message %q", text)
+	}
+}
+
+func TestStaleKeyboardsAreRemovedInBackground(t *testing.T) {
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				bs.SendMessage("buttons", SendMessageInlineKeyboard(NewInlineKeyboard(NewInlineRow(NewInlineButton("press", "press")))))
+			}).
+			Build()
+	}
+	cfg := newTestConfig(root, 1)
+	mock := newTestMock(t, cfg)
+	const numKeyboards = 5
+	for i := range numKeyboards {
+		mock.SendAndWait(1, fmt.Sprintf("message %d", i))
+	}
+	mock.Stop()
+
+	removedKeyboards := func(mock *MockBot[int]) int {
+		var removed int
+		for _, request := range mock.Requests() {
+			if _, ok := request.(tgbotapi.EditMessageReplyMarkupConfig); ok {
+				removed++
+			}
+		}
+		return removed
+	}
+
+	restarted := newTestMock(t, cfg)
+	restarted.SendAndWait(1, "hi")
+	if removed := removedKeyboards(restarted); removed == numKeyboards {
+		t.Errorf("expected the keyboards to be removed in the background")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for removedKeyboards(restarted) < numKeyboards {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d removed keyboards, got %d", numKeyboards, removedKeyboards(restarted))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+// Package bottytest provides helpers to test bots built with botty.
+package bottytest
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/frairon/botty"
+)
+
+// ConversationTester drives a conversation of a single user with a mocked bot and asserts
+// the bot's answers, failing the test with the expected and actual values:
+//
+//	ct := NewConversationTester(t, cfg, 1)
+//	defer ct.Stop()
+//	ct.Send("hi").Expect("Welcome").
+//		Press("Settings").ExpectButtons("↩ Back", "🔕 Pause notifications").
+//		PressInline("Next").ExpectInline("Prev", "Next")
+type ConversationTester[T any] struct {
+	t      testing.TB
+	mock   *botty.MockBot[T]
+	userId botty.UserId
+}
+
+// NewConversationTester runs a mocked bot for the config, see botty.NewMockBot, and
+// registers the user if the config's UserManager does not know it yet.
+func NewConversationTester[T any](t testing.TB, cfg *botty.Config[T], userId botty.UserId) *ConversationTester[T] {
+	t.Helper()
+	if !cfg.UserManager.UserExists(userId) {
+		if err := cfg.UserManager.AddUser(userId, "tester"); err != nil {
+			t.Fatalf("error adding user %d: %v", userId, err)
+		}
+	}
+	mock, err := botty.NewMockBot(cfg)
+	if err != nil {
+		t.Fatalf("error creating mock bot: %v", err)
+	}
+	return &ConversationTester[T]{
+		t:      t,
+		mock:   mock,
+		userId: userId,
+	}
+}
+
+// Mock returns the underlying mock, e.g. to inspect the bot
+func (ct *ConversationTester[T]) Mock() *botty.MockBot[T] {
+	return ct.mock
+}
+
+func (ct *ConversationTester[T]) Stop() {
+	ct.mock.Stop()
+}
+
+// Send sends the text as the user
+func (ct *ConversationTester[T]) Send(text string) *ConversationTester[T] {
+	ct.mock.SendAndWait(ct.userId, text)
+	return ct
+}
+
+// Command sends the command with its arguments as the user, e.g. Command("home")
+func (ct *ConversationTester[T]) Command(command string, args ...string) *ConversationTester[T] {
+	ct.mock.SendCommand(ct.userId, command, args...)
+	return ct
+}
+
+// Press presses a button of the last message's reply keyboard
+func (ct *ConversationTester[T]) Press(label string) *ConversationTester[T] {
+	ct.t.Helper()
+	buttons := ct.mock.LastMessageButtons()
+	if !slices.Contains(buttons, label) {
+		ct.t.Errorf("cannot press %q, last message has buttons %q", label, buttons)
+		return ct
+	}
+	return ct.Send(label)
+}
+
+// PressInline presses a button of the last message's inline keyboard
+func (ct *ConversationTester[T]) PressInline(label string) *ConversationTester[T] {
+	ct.t.Helper()
+	buttons := ct.mock.LastMessageInlineButtons()
+	idx := slices.IndexFunc(buttons, func(button botty.InlineButton) bool { return button.Label == label })
+	if idx < 0 {
+		ct.t.Errorf("cannot press inline %q, last message has inline buttons %q", label, inlineLabels(buttons))
+		return ct
+	}
+	ct.mock.PressInline(ct.userId, ct.mock.LastMessageID, buttons[idx].Data)
+	return ct
+}
+
+// Expect asserts that the last message contains text
+func (ct *ConversationTester[T]) Expect(text string) *ConversationTester[T] {
+	ct.t.Helper()
+	if got := ct.mock.LastMessageText(); !strings.Contains(got, text) {
+		ct.t.Errorf("last message does not contain expected text\nexpected: %q\n     got: %q", text, got)
+	}
+	return ct
+}
+
+// ExpectButtons asserts the buttons of the last message's reply keyboard
+func (ct *ConversationTester[T]) ExpectButtons(labels ...string) *ConversationTester[T] {
+	ct.t.Helper()
+	if got := ct.mock.LastMessageButtons(); !slices.Equal(got, labels) {
+		ct.t.Errorf("unexpected buttons\nexpected: %q\n     got: %q", labels, got)
+	}
+	return ct
+}
+
+// ExpectInline asserts the labels of the last message's inline buttons
+func (ct *ConversationTester[T]) ExpectInline(labels ...string) *ConversationTester[T] {
+	ct.t.Helper()
+	if got := inlineLabels(ct.mock.LastMessageInlineButtons()); !slices.Equal(got, labels) {
+		ct.t.Errorf("unexpected inline buttons\nexpected: %q\n     got: %q", labels, got)
+	}
+	return ct
+}
+
+func inlineLabels(buttons []botty.InlineButton) []string {
+	var labels []string
+	for _, button := range buttons {
+		labels = append(labels, button.Label)
+	}
+	return labels
+}
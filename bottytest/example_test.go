@@ -0,0 +1,46 @@
+package bottytest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/frairon/botty"
+	"github.com/frairon/botty/bottytest"
+)
+
+// counterState counts presses of an inline button
+func counterState() botty.State[int] {
+	var count int
+	increment := botty.NewInlineButton("+1", "increment")
+	counter := botty.SendMessageInlineKeyboard(botty.NewInlineKeyboard(botty.NewInlineRow(increment)))
+	menu := botty.SendMessageWithKeyboard(botty.KeyboardFromStrings(1, "Count"))
+
+	return botty.NewStateBuilder[int]().
+		OnActivate(func(bs botty.Session[int]) {
+			bs.SendMessage("Welcome", menu)
+		}).
+		OnMessage(func(bs botty.Session[int], msg botty.ChatMessage) {
+			bs.SendMessage("Press Count to start counting", menu)
+		}).
+		OnButton("Count", func(bs botty.Session[int], msg botty.ChatMessage) {
+			bs.SendMessage(fmt.Sprintf("count: %d", count), counter)
+		}).
+		OnInlineButton(increment, func(bs botty.Session[int], query botty.CallbackQuery) bool {
+			count++
+			bs.SendMessage(fmt.Sprintf("count: %d", count), counter)
+			return true
+		}).
+		Build()
+}
+
+func TestCounterConversation(t *testing.T) {
+	cfg := botty.NewConfig[int]("", botty.NewInMemoryAppStateManager[int](), botty.NewInMemoryUserManager(), counterState)
+
+	ct := bottytest.NewConversationTester(t, cfg, 1)
+	defer ct.Stop()
+
+	ct.Send("hi").Expect("Press Count").ExpectButtons("Count").
+		Press("Count").Expect("count: 0").ExpectInline("+1").
+		PressInline("+1").Expect("count: 1").
+		PressInline("+1").Expect("count: 2")
+}
@@ -1,7 +1,11 @@
 package botty
 
 import (
+	"fmt"
+	"html"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 type CommandHandler[T any] interface {
@@ -14,6 +18,15 @@ func (f FuncCommandHandler[T]) Handle(bs Session[T], command string, args ...str
 	return f(bs, command, args...)
 }
 
+// BotCommandHandler registers a command for all states with its description for the command menu,
+// see Config.CommandHandlers
+type BotCommandHandler[T any] struct {
+	// the command without leading slash, e.g. "add"
+	Command     string
+	Description string
+	Handler     CommandHandler[T]
+}
+
 type HandlerMap[T any] map[string]CommandHandler[T]
 
 func (hm HandlerMap[T]) Handle(bs Session[T], command string, args ...string) bool {
@@ -27,3 +40,182 @@ func (hm HandlerMap[T]) Handle(bs Session[T], command string, args ...string) bo
 func (hm HandlerMap[T]) Set(command string, sc CommandHandler[T]) {
 	hm[strings.TrimPrefix(command, "/")] = sc
 }
+
+// SplitCommandArgs splits command arguments at whitespace, keeping quoted arguments together
+// like a shell does, e.g. `add "Some Name" 3` becomes ["add", "Some Name", "3"].
+// Single and double quotes are supported, a backslash escapes the next character
+// except within single quotes. Empty arguments are only returned if quoted explicitly.
+func SplitCommandArgs(args string) []string {
+	var (
+		result  []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range args {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				result = append(result, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		result = append(result, current.String())
+	}
+	return result
+}
+
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgFloat
+	// one of ArgSpec.Values
+	ArgEnum
+)
+
+// ArgSpec declares a command argument. Optional arguments must follow the required ones.
+type ArgSpec struct {
+	Name     string
+	Type     ArgType
+	Required bool
+	// allowed values of ArgEnum arguments
+	Values []string
+}
+
+// CommandArgs holds the arguments parsed by ParseCommandArgs
+type CommandArgs struct {
+	values map[string]any
+}
+
+// Has returns whether the (optional) argument was passed
+func (ca CommandArgs) Has(name string) bool {
+	_, ok := ca.values[name]
+	return ok
+}
+
+// String returns a string or enum argument, empty if not passed
+func (ca CommandArgs) String(name string) string {
+	value, _ := ca.values[name].(string)
+	return value
+}
+
+// Int returns an int argument, 0 if not passed
+func (ca CommandArgs) Int(name string) int {
+	value, _ := ca.values[name].(int)
+	return value
+}
+
+// Float returns a float argument, 0 if not passed
+func (ca CommandArgs) Float(name string) float64 {
+	value, _ := ca.values[name].(float64)
+	return value
+}
+
+// ParseCommandArgs parses the args according to the specs
+func ParseCommandArgs(specs []ArgSpec, args []string) (CommandArgs, error) {
+	parsed := CommandArgs{values: make(map[string]any, len(args))}
+
+	if len(args) > len(specs) {
+		return parsed, fmt.Errorf("too many arguments")
+	}
+
+	for idx, spec := range specs {
+		if idx >= len(args) {
+			if spec.Required {
+				return parsed, fmt.Errorf("missing argument <%s>", spec.Name)
+			}
+			continue
+		}
+
+		arg := args[idx]
+		switch spec.Type {
+		case ArgString:
+			parsed.values[spec.Name] = arg
+		case ArgInt:
+			value, err := strconv.Atoi(arg)
+			if err != nil {
+				return parsed, fmt.Errorf("<%s> must be a number, got '%s'", spec.Name, arg)
+			}
+			parsed.values[spec.Name] = value
+		case ArgFloat:
+			value, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return parsed, fmt.Errorf("<%s> must be a decimal number, got '%s'", spec.Name, arg)
+			}
+			parsed.values[spec.Name] = value
+		case ArgEnum:
+			var valid bool
+			for _, value := range spec.Values {
+				valid = valid || value == arg
+			}
+			if !valid {
+				return parsed, fmt.Errorf("<%s> must be one of %s, got '%s'", spec.Name, strings.Join(spec.Values, "|"), arg)
+			}
+			parsed.values[spec.Name] = arg
+		default:
+			return parsed, fmt.Errorf("<%s> has unknown type %d", spec.Name, spec.Type)
+		}
+	}
+	return parsed, nil
+}
+
+// CommandUsage describes how to call the command, e.g. "/add <name> [count]"
+func CommandUsage(command string, specs []ArgSpec) string {
+	usage := "/" + strings.TrimPrefix(command, "/")
+	for _, spec := range specs {
+		name := spec.Name
+		if spec.Type == ArgEnum {
+			name = strings.Join(spec.Values, "|")
+		}
+		if spec.Required {
+			usage += " <" + name + ">"
+		} else {
+			usage += " [" + name + "]"
+		}
+	}
+	return usage
+}
+
+// NewTypedCommandHandler creates a handler for the command, which parses the arguments according to the
+// specs before calling handler. If parsing fails, the error and the command's usage is sent to the user.
+func NewTypedCommandHandler[T any](command string, specs []ArgSpec, handler func(bs Session[T], args CommandArgs)) CommandHandler[T] {
+	command = strings.TrimPrefix(command, "/")
+	return FuncCommandHandler[T](func(bs Session[T], cmd string, args ...string) bool {
+		if cmd != command {
+			return false
+		}
+		parsed, err := ParseCommandArgs(specs, args)
+		if err != nil {
+			// messages are sent as HTML, so the <arg> placeholders need escaping
+			bs.SendMessage(html.EscapeString(fmt.Sprintf("%v\nUsage: %s", err, CommandUsage(command, specs))))
+			return true
+		}
+		handler(bs, parsed)
+		return true
+	})
+}
@@ -0,0 +1,176 @@
+package botty
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestCommandHandlers(t *testing.T) {
+	cfg := newTestConfig(newEchoState, 1)
+	cfg.CommandHandlers = []BotCommandHandler[int]{
+		{
+			Command:     "add",
+			Description: "adds the numbers",
+			Handler: NewTypedCommandHandler("add", []ArgSpec{{Name: "a", Type: ArgInt, Required: true}, {Name: "b", Type: ArgInt, Required: true}},
+				func(bs Session[int], args CommandArgs) {
+					bs.SendMessage("sum " + fmt.Sprint(args.Int("a")+args.Int("b")))
+				}),
+		},
+		{
+			// overrides the built-in command
+			Command:     CommandHelp.Command,
+			Description: "custom help",
+			Handler: FuncCommandHandler[int](func(bs Session[int], command string, args ...string) bool {
+				bs.SendMessage("custom help")
+				return true
+			}),
+		},
+	}
+	cfg.Commands = []tgbotapi.BotCommand{{Command: "extra", Description: "handled elsewhere"}, CommandMain}
+	mock := newTestMock(t, cfg)
+	mock.SendAndWait(1, "hi")
+
+	mock.SendCommand(1, "add", "1", "2")
+	if text := mock.LastMessageText(); text != "sum 3" {
+		t.Errorf("unexpected answer to add: %q", text)
+	}
+	mock.SendCommand(1, "help")
+	if text := mock.LastMessageText(); text != "custom help" {
+		t.Errorf("unexpected answer to help: %q", text)
+	}
+
+	var menu []string
+	for _, command := range mock.bot.botCommands() {
+		menu = append(menu, command.Command+": "+command.Description)
+	}
+	expected := []string{
+		"add: adds the numbers",
+		"help: custom help",
+		CommandMain.Command + ": " + CommandMain.Description,
+		CommandUsers.Command + ": " + CommandUsers.Description,
+		CommandCancel.Command + ": " + CommandCancel.Description,
+		CommandReload.Command + ": " + CommandReload.Description,
+		"extra: handled elsewhere",
+	}
+	if !slices.Equal(menu, expected) {
+		t.Errorf("unexpected command menu\nexpected: %q\n     got: %q", expected, menu)
+	}
+}
+
+func TestCommandMenu(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		cfg := newTestConfig(newEchoState, 1)
+		cfg.DisableCommandMenu = disabled
+		mock := newTestMock(t, cfg)
+
+		var set int
+		for _, request := range mock.Requests() {
+			if _, ok := request.(tgbotapi.SetMyCommandsConfig); ok {
+				set++
+			}
+		}
+		if expected := map[bool]int{false: 1, true: 0}[disabled]; set != expected {
+			t.Errorf("disabled %t: expected the command menu to be set %d times, got %d", disabled, expected, set)
+		}
+	}
+}
+
+func TestCommandHandlersAreValidated(t *testing.T) {
+	handler := FuncCommandHandler[int](func(bs Session[int], command string, args ...string) bool { return true })
+	for name, handlers := range map[string][]BotCommandHandler[int]{
+		"duplicate":      {{Command: "add", Description: "add", Handler: handler}, {Command: "add", Description: "again", Handler: handler}},
+		"slash":          {{Command: "/add", Description: "add", Handler: handler}},
+		"no description": {{Command: "add", Handler: handler}},
+		"no handler":     {{Command: "add", Description: "add"}},
+	} {
+		cfg := newTestConfig(newEchoState)
+		cfg.CommandHandlers = handlers
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSplitCommandArgs(t *testing.T) {
+	for args, expected := range map[string][]string{
+		``:                      nil,
+		`   `:                   nil,
+		`add 3`:                 {"add", "3"},
+		`add  3 `:               {"add", "3"},
+		`add "Some Name" 3`:     {"add", "Some Name", "3"},
+		`add 'it''s' x`:         {"add", "its", "x"},
+		`say "a \"quoted\" b"`:  {"say", `a "quoted" b`},
+		`say 'no \escape'`:      {"say", `no \escape`},
+		`path a\ b`:             {"path", "a b"},
+		`empty "" ''`:           {"empty", "", ""},
+		`joined"quoted part"x`:  {"joinedquoted partx"},
+		"tabs\tand\nnewlines":   {"tabs", "and", "newlines"},
+		`unterminated "quote x`: {"unterminated", "quote x"},
+	} {
+		if got := SplitCommandArgs(args); !slices.Equal(got, expected) {
+			t.Errorf("%s: expected %q, got %q", args, expected, got)
+		}
+	}
+}
+
+func TestParseCommandArgs(t *testing.T) {
+	specs := []ArgSpec{
+		{Name: "name", Type: ArgString, Required: true},
+		{Name: "count", Type: ArgInt, Required: true},
+		{Name: "unit", Type: ArgEnum, Values: []string{"kg", "g"}},
+		{Name: "factor", Type: ArgFloat},
+	}
+
+	args, err := ParseCommandArgs(specs, []string{"flour", "3", "kg", "1.5"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.String("name") != "flour" || args.Int("count") != 3 || args.String("unit") != "kg" || args.Float("factor") != 1.5 {
+		t.Errorf("unexpected arguments %v", args.values)
+	}
+
+	args, err = ParseCommandArgs(specs, []string{"flour", "3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Has("unit") || args.Has("factor") || args.String("unit") != "" || args.Float("factor") != 0 {
+		t.Errorf("expected optional arguments to be missing, got %v", args.values)
+	}
+
+	for name, invalid := range map[string][]string{
+		"missing required": {"flour"},
+		"not an int":       {"flour", "three"},
+		"unknown enum":     {"flour", "3", "lbs"},
+		"not a float":      {"flour", "3", "g", "x"},
+		"too many":         {"flour", "3", "g", "1", "extra"},
+	} {
+		if _, err := ParseCommandArgs(specs, invalid); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if usage := CommandUsage("/add", specs); usage != "/add <name> <count> [kg|g] [factor]" {
+		t.Errorf("unexpected usage %q", usage)
+	}
+}
+
+func TestTypedCommandHandlerSendsUsage(t *testing.T) {
+	cfg := newTestConfig(newEchoState, 1)
+	cfg.CommandHandlers = []BotCommandHandler[int]{{
+		Command:     "add",
+		Description: "adds",
+		Handler: NewTypedCommandHandler("add", []ArgSpec{{Name: "count", Type: ArgInt, Required: true}},
+			func(bs Session[int], args CommandArgs) {}),
+	}}
+	mock := newTestMock(t, cfg)
+	mock.SendAndWait(1, "hi")
+
+	mock.SendCommand(1, "add", "x")
+	if text := mock.LastMessageText(); !strings.Contains(text, "Usage: /add &lt;count&gt;") {
+		t.Errorf("expected the escaped usage, got %q", text)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type promptOptions struct {
@@ -80,6 +81,109 @@ func SelectState[O, T any](text string, items []O, accept func(bs Session[T], it
 	}
 }
 
+// InputState prompts the user for a value and parses the answer. On parse errors the error is
+// reported and the user is asked again. onValid is called with the parsed value and is responsible
+// for navigating away from the state.
+func InputState[T any](prompt string, parse func(string) (any, error), onValid func(bs Session[T], v any)) State[T] {
+	return &functionState[T]{
+		activate: func(bs Session[T]) {
+			bs.SendMessage(prompt)
+		},
+		handleMessage: func(bs Session[T], msg ChatMessage) {
+			value, err := parse(strings.TrimSpace(msg.Text()))
+			if err != nil {
+				bs.SendMessage(fmt.Sprintf("Invalid value: %v. Enter valid value.", err))
+				return
+			}
+			onValid(bs, value)
+		},
+	}
+}
+
+// ParseInt parses an int, to be used with InputState
+func ParseInt(value string) (any, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a number", value)
+	}
+	return parsed, nil
+}
+
+// ParseFloat parses a float64, to be used with InputState
+func ParseFloat(value string) (any, error) {
+	parsed, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a decimal number", value)
+	}
+	return parsed, nil
+}
+
+// ParseDate parses a date in the format YYYY-MM-DD as time.Time, to be used with InputState
+func ParseDate(value string) (any, error) {
+	parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a date (YYYY-MM-DD)", value)
+	}
+	return parsed, nil
+}
+
+// GalleryState shows one media item at a time with inline buttons to navigate through them,
+// editing the same message. caption is optional and overrides the items' captions.
+func GalleryState[T any](items []MediaItem, caption func(i int) string) State[T] {
+	var (
+		prev    = NewInlineButton("◀", "_gallery:prev")
+		next    = NewInlineButton("▶", "_gallery:next")
+		counter = "_gallery:counter"
+
+		idx int
+		msg Message
+	)
+
+	item := func() MediaItem {
+		current := items[idx]
+		if caption != nil {
+			current.Caption = caption(idx)
+		}
+		return current
+	}
+	keyboard := func() InlineKeyboard {
+		return NewInlineKeyboard(NewInlineRow(prev,
+			NewInlineButton(fmt.Sprintf("%d/%d", idx+1, len(items)), counter),
+			next))
+	}
+	move := func(delta int) func(bs Session[T], query CallbackQuery) bool {
+		return func(bs Session[T], query CallbackQuery) bool {
+			idx = (idx + delta + len(items)) % len(items)
+			msg = bs.UpdateMessageMedia(query.MessageID(), item(), keyboard())
+			bs.AnswerCallbackQuery(query.ID(), "", false)
+			return true
+		}
+	}
+
+	return NewStateBuilder[T]().
+		OnActivate(func(bs Session[T]) {
+			if len(items) == 0 {
+				bs.SendMessage("Nothing to show.")
+				return
+			}
+			idx = min(idx, len(items)-1)
+			msg = bs.SendMedia(item(), SendMessageInlineKeyboard(keyboard()))
+		}).
+		OnInlineButton(prev, move(-1)).
+		OnInlineButton(next, move(1)).
+		OnInlineButton(NewInlineButton("", counter), func(bs Session[T], query CallbackQuery) bool {
+			bs.AnswerCallbackQuery(query.ID(), "", false)
+			return true
+		}).
+		OnBeforeLeave(func(bs Session[T]) {
+			if msg != nil {
+				msg.RemoveKeyboardForMessage()
+				msg = nil
+			}
+		}).
+		Build()
+}
+
 func TernaryButton(cond bool, trueButton, falseButton InlineButton) InlineButton {
 	if cond {
 		return trueButton
@@ -88,6 +192,16 @@ func TernaryButton(cond bool, trueButton, falseButton InlineButton) InlineButton
 }
 
 func NewMultiMessageHandler[T any](handlers ...InlineMessageHandler[T]) State[T] {
+	return newMultiMessageHandler(false, handlers)
+}
+
+// NewMultiMessageHandlerOwnerOnly is like NewMultiMessageHandler, but only the user that entered the
+// state may press the messages' buttons, e.g. in group chats. See SendMessageOwnerOnly.
+func NewMultiMessageHandlerOwnerOnly[T any](handlers ...InlineMessageHandler[T]) State[T] {
+	return newMultiMessageHandler(true, handlers)
+}
+
+func newMultiMessageHandler[T any](ownerOnly bool, handlers []InlineMessageHandler[T]) State[T] {
 	handlersByMsg := map[int]InlineMessageHandler[T]{}
 
 	return NewStateBuilder[T]().
@@ -98,7 +212,11 @@ func NewMultiMessageHandler[T any](handlers ...InlineMessageHandler[T]) State[T]
 					bs.SendError(err)
 					return
 				}
-				msgId := bs.SendMessage(msg, SendMessageInlineKeyboard(keyboard)).ID()
+				opts := []SendMessageOption{SendMessageInlineKeyboard(keyboard)}
+				if ownerOnly {
+					opts = append(opts, SendMessageOwnerOnly())
+				}
+				msgId := bs.SendMessage(msg, opts...).ID()
 				handlersByMsg[msgId] = handler
 			}
 		}).
@@ -146,7 +264,6 @@ func NewMessageHandler[T any](handleQuery InlineMessageHandler[T]) State[T] {
 			lastMessageId = bs.SendMessage(msg, SendMessageInlineKeyboard(keyboard)).ID()
 		}).
 		OnCallbackQuery(func(bs Session[T], query CallbackQuery) bool {
-			log.Printf("callback: %#v", query)
 			content, keyboard, err := handleQuery(bs, query.Data())
 			if err != nil {
 				bs.SendError(err)
@@ -171,3 +288,70 @@ func NewMessageHandler[T any](handleQuery InlineMessageHandler[T]) State[T] {
 }
 
 type InlineMessageHandler[T any] func(bs Session[T], query string) (string, InlineKeyboard, error)
+
+// AutoRefreshState sends the rendered message when entered and updates it every interval
+// until the state is left or the bot stops, e.g. for live dashboards.
+func AutoRefreshState[T any](interval time.Duration, render func(bs Session[T]) (string, InlineKeyboard)) State[T] {
+	var (
+		self State[T]
+		msg  Message
+		// closed to stop the current refresh goroutine
+		stop chan struct{}
+	)
+
+	stopRefresh := func(bs Session[T]) {
+		if stop != nil {
+			close(stop)
+			stop = nil
+		}
+	}
+
+	start := func(bs Session[T]) {
+		stopRefresh(bs)
+
+		text, keyboard := render(bs)
+		msg = bs.SendMessage(text, SendMessageInlineKeyboard(keyboard))
+		if interval <= 0 {
+			return
+		}
+
+		done := make(chan struct{})
+		stop = done
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-bs.Context().Done():
+					return
+				case <-ticker.C:
+				}
+
+				bs.Do(func(bs Session[T]) {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					// the state might have been dropped without leaving it, e.g. by ResetToState
+					if bs.CurrentState() != self {
+						if stop == done {
+							stopRefresh(bs)
+						}
+						return
+					}
+					text, keyboard := render(bs)
+					msg.UpdateMessage("", text, SendMessageInlineKeyboard(keyboard))
+				})
+			}
+		}()
+	}
+
+	self = NewStateBuilder[T]().
+		OnActivate(start).
+		OnBeforeLeave(stopRefresh).
+		Build()
+	return self
+}
@@ -1,7 +1,11 @@
 package botty
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -12,11 +16,44 @@ type User struct {
 	Name string
 }
 
+// SessionSettings are the user's preferences of a session
+type SessionSettings struct {
+	// if set, all messages are sent silently
+	PauseAllNotifications bool
+	// IANA name of the user's time zone, e.g. "Europe/Berlin", empty for local time. See Session.TimeZone.
+	TimeZone string
+}
+
 type StoredSessionState[T any] struct {
 	UserID     UserId
 	ChatID     ChatId
 	LastAction time.Time
-	State      T
+	Settings   SessionSettings
+	// recently sent messages with inline keyboard, whose keyboards are removed after loading
+	InlineMessages []MessageId
+	State          T
+}
+
+type MemberStatus string
+
+const (
+	MemberStatusCreator       MemberStatus = "creator"
+	MemberStatusAdministrator MemberStatus = "administrator"
+	MemberStatusMember        MemberStatus = "member"
+	MemberStatusRestricted    MemberStatus = "restricted"
+	MemberStatusLeft          MemberStatus = "left"
+	MemberStatusKicked        MemberStatus = "kicked"
+)
+
+// MembershipChange describes a change of the bot's status in a chat.
+// In private chats, NewStatus is MemberStatusKicked if the user blocked the bot
+// and MemberStatusMember if they unblocked it.
+type MembershipChange struct {
+	// user that performed the change
+	UserID    UserId
+	ChatID    ChatId
+	OldStatus MemberStatus
+	NewStatus MemberStatus
 }
 
 type UserManager interface {
@@ -26,14 +63,88 @@ type UserManager interface {
 	DeleteUser(userID UserId) error
 }
 
+// UserAdder can be implemented by a UserManager to check and add a user atomically,
+// e.g. in a single database transaction.
+type UserAdder interface {
+	// AddUserIfNotExists adds the user unless it exists and returns whether it was added
+	AddUserIfNotExists(userID UserId, userName string) (bool, error)
+}
+
 type AppStateManager[T any] interface {
 	CreateAppState(userId UserId, chatId ChatId) T
 	StoreSessionState(state StoredSessionState[T]) error
+	DeleteSessionState(userID UserId, chatID ChatId) error
 
 	// rename to list sessions
 	LoadSessionStates() ([]StoredSessionState[T], error)
 }
 
+// ErrStorePermanent can be wrapped by an AppStateManager's errors if retrying won't fix them,
+// e.g. if a state cannot be serialized. The bot backs off for them like for other errors,
+// but reports them as permanent, see StoreError.
+var ErrStorePermanent = errors.New("permanent store error")
+
+// StoreError describes a failure to store the sessions, see Config.OnStoreError
+type StoreError struct {
+	Err error
+	// number of consecutive failures
+	Failures int
+	// set if all errors wrap ErrStorePermanent, so retrying won't help
+	Permanent bool
+	// time until the next attempt
+	RetryIn time.Duration
+}
+
+func (e StoreError) Error() string {
+	return fmt.Sprintf("error storing sessions (failure %d): %v", e.Failures, e.Err)
+}
+
+func (e StoreError) Unwrap() error {
+	return e.Err
+}
+
+const maxStoreBackoff = time.Hour
+
+const (
+	defaultStoreInterval   = 60 * time.Second
+	defaultAutoTypingDelay = 500 * time.Millisecond
+	defaultPollTimeout     = 60
+)
+
+// BatchSessionStorer can be implemented by an AppStateManager to store all sessions at once
+// instead of calling StoreSessionState for each session.
+type BatchSessionStorer[T any] interface {
+	StoreSessionStates(states []StoredSessionState[T]) error
+}
+
+// DefaultAllowedUpdates are the update types the bot handles
+var DefaultAllowedUpdates = []string{
+	tgbotapi.UpdateTypeMessage,
+	tgbotapi.UpdateTypeEditedMessage,
+	tgbotapi.UpdateTypeCallbackQuery,
+	tgbotapi.UpdateTypeInlineQuery,
+	tgbotapi.UpdateTypePoll,
+	tgbotapi.UpdateTypePollAnswer,
+	tgbotapi.UpdateTypeMyChatMember,
+}
+
+var knownUpdateTypes = []string{
+	tgbotapi.UpdateTypeMessage,
+	tgbotapi.UpdateTypeEditedMessage,
+	tgbotapi.UpdateTypeChannelPost,
+	tgbotapi.UpdateTypeEditedChannelPost,
+	tgbotapi.UpdateTypeInlineQuery,
+	tgbotapi.UpdateTypeChosenInlineResult,
+	tgbotapi.UpdateTypeCallbackQuery,
+	tgbotapi.UpdateTypeShippingQuery,
+	tgbotapi.UpdateTypePreCheckoutQuery,
+	tgbotapi.UpdateTypePoll,
+	tgbotapi.UpdateTypePollAnswer,
+	tgbotapi.UpdateTypeMyChatMember,
+	tgbotapi.UpdateTypeChatMember,
+	"chat_join_request",
+}
+
 type Config[T any] struct {
 	// bot token
 	Token string
@@ -44,7 +155,114 @@ type Config[T any] struct {
 
 	UserManager UserManager
 
+	// maximum number of states on a session's stack. If exceeded by PushState,
+	// the oldest states are dropped. 0 means unlimited.
+	MaxStackDepth int
+
+	// interval in which the sessions are stored, defaults to 60 seconds.
+	// After failures, storing backs off exponentially up to an hour.
+	StoreInterval time.Duration
+	// called when storing the sessions fails, e.g. to alert the operator
+	OnStoreError func(err StoreError)
+
+	// options for the user management state opened by /users
+	UsersListOptions []UsersListOption
+
+	// if set, messages sent before the bot started are dropped. Telegram keeps updates for up to 24 hours,
+	// so after a downtime the bot would otherwise handle the whole backlog, e.g. replay commands that
+	// users sent long ago and don't expect to be executed anymore.
+	// Only applies to messages, as e.g. callback queries don't carry a date.
+	DropStaleUpdates bool
+
+	// shown by /help if the current state has no help, see StateBuilder.WithHelp
+	HelpText string
+
+	// enables the /status command showing the bot's stats, see StatusState
+	EnableStatusCommand bool
+
+	// by default, the bot sets its command menu on startup, overwriting commands set elsewhere,
+	// e.g. via BotFather. Set this to keep the existing menu.
+	DisableCommandMenu bool
+	// commands handled in every state, unless the state handles them itself. They are added to
+	// the bot's command menu with their description and take precedence over the built-in commands.
+	CommandHandlers []BotCommandHandler[T]
+	// extra entries for the bot's command menu, e.g. commands handled by states
+	// or by Session.SetCommandHandler. Not used if DisableCommandMenu is set.
+	Commands []tgbotapi.BotCommand
+
 	Connect func(token string) (TGApi, error)
+
+	// seconds a long poll for updates waits for new updates, defaults to 60.
+	// Shorter timeouts cause more requests without reducing latency.
+	PollTimeout int
+	// if set, all requests to the telegram API and their responses are logged, e.g. to debug keyboards
+	DebugAPI bool
+
+	// update types the bot receives, e.g. tgbotapi.UpdateTypeMessage. Defaults to the
+	// types the bot handles, see DefaultAllowedUpdates.
+	AllowedUpdates []string
+	// number of updates buffered while the bot is busy handling, defaults to the library's 100.
+	// Only applies to the default telegram API created by Connect.
+	UpdateBuffer int
+
+	// applied to the text of every sent or updated message, e.g. to add a footer
+	MessageTransformer func(text string) string
+
+	// called with every text the bot sent or updated successfully, e.g. for an audit trail.
+	// kind is one of OutgoingMessage, OutgoingUpdate or OutgoingError.
+	// It's called by a single goroutine in the order the texts were sent. If it falls behind by more
+	// than 100 texts, further texts are dropped.
+	// Note that the texts contain whatever the bot sends to its users, i.e. possibly personal data,
+	// so store and protect them accordingly.
+	OnOutgoing func(chatId ChatId, text string, kind string)
+
+	// if set, the typing action is shown while a state's handler takes longer than AutoTypingDelay
+	AutoTyping bool
+	// defaults to 500ms
+	AutoTypingDelay time.Duration
+
+	// if set, messages notify the user unless sent with SendMessageSilent.
+	// Otherwise (default) messages are silent unless sent with SendMessageWithNotification.
+	DefaultNotification bool
+
+	// called for updates of unknown users while the bot does not accept new users,
+	// e.g. to tell them how to get access. Unauthorized updates are dropped silently if not set.
+	OnUnauthorized func(api TGApi, update tgbotapi.Update)
+
+	// called after a new user was accepted and their session was created, e.g. to show onboarding.
+	// Not called for users that already exist in the UserManager.
+	OnNewUser func(bs Session[T])
+
+	// answers inline queries (@bot query), which requires inline mode to be enabled for the bot.
+	// It's called for unknown users as well, without adding them, check the query's From if needed.
+	InlineQueryHandler func(ctx context.Context, query InlineQuery) []InlineResult
+	// seconds telegram may cache the inline query results, 0 for telegram's default
+	InlineQueryCacheTime int
+	// if set, telegram caches results per user
+	InlineQueryPersonal bool
+
+	// called for updates that neither the current state nor the bot's commands handle,
+	// e.g. to reply "I didn't understand that".
+	OnUnhandled func(bs Session[T], update UpdateInfo)
+
+	// called for callback queries that the current state does not handle, e.g. buttons of
+	// messages sent before a restart. Defaults to alerting the user and removing the message's keyboard.
+	OnExpiredCallback func(bs Session[T], query CallbackQuery)
+
+	// called when a user answers a poll sent by Session.SendPoll. Polls are
+	// not persisted, so answers for polls sent before a restart are dropped.
+	PollAnswerHandler func(bs Session[T], answer PollAnswer)
+
+	// called when a handler panics while handling an update or an action passed to Do. The bot recovers and keeps running.
+	// Defaults to telling the user that something went wrong.
+	OnPanic func(bs Session[T], recovered any)
+
+	// called when a web app opened by a reply keyboard button sends data, see WithWebApp.
+	// If set, the bot polls the updates itself, as the telegram library drops the web app data.
+	WebAppDataHandler func(bs Session[T], data WebAppData)
+
+	// called when the bot's membership in a chat changes, e.g. when a user blocks the bot.
+	OnMembershipChange func(change MembershipChange)
 }
 
 func NewConfig[T any](token string, appStateManager AppStateManager[T], userManager UserManager, rootState StateFactory[T]) *Config[T] {
@@ -54,6 +272,7 @@ func NewConfig[T any](token string, appStateManager AppStateManager[T], userMana
 		AppStateManager: appStateManager,
 		UserManager:     userManager,
 		RootState:       rootState,
+		StoreInterval:   defaultStoreInterval,
 		Connect: func(token string) (TGApi, error) {
 			api, err := tgbotapi.NewBotAPI(token)
 			if err != nil {
@@ -72,6 +291,33 @@ func (c *Config[T]) validate() error {
 	if c.UserManager == nil {
 		return fmt.Errorf("user manager must be provided")
 	}
+	if c.RootState == nil {
+		return fmt.Errorf("root state must be provided")
+	}
+	if c.RootState() == nil {
+		return fmt.Errorf("root state factory must not return nil")
+	}
+	registered := make(map[string]bool, len(c.CommandHandlers))
+	for _, handler := range c.CommandHandlers {
+		if handler.Command == "" || strings.HasPrefix(handler.Command, "/") {
+			return fmt.Errorf("invalid command %q, must be non-empty and without leading slash", handler.Command)
+		}
+		if handler.Description == "" {
+			return fmt.Errorf("command %q needs a description", handler.Command)
+		}
+		if handler.Handler == nil {
+			return fmt.Errorf("command %q has no handler", handler.Command)
+		}
+		if registered[handler.Command] {
+			return fmt.Errorf("command %q is registered twice", handler.Command)
+		}
+		registered[handler.Command] = true
+	}
+	for _, updateType := range c.AllowedUpdates {
+		if !slices.Contains(knownUpdateTypes, updateType) {
+			return fmt.Errorf("unknown update type %q in allowed updates", updateType)
+		}
+	}
 
 	return nil
 }
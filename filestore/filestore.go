@@ -0,0 +1,208 @@
+// Package filestore provides a UserManager and AppStateManager storing users and
+// session states in a single JSON file.
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/frairon/botty"
+)
+
+type content[T any] struct {
+	Users    map[botty.UserId]botty.User                  `json:"users"`
+	Sessions map[botty.ChatId]botty.StoredSessionState[T] `json:"sessions"`
+}
+
+// Store implements botty.UserManager and botty.AppStateManager[T].
+// The app state T is (de)serialized using encoding/json.
+type Store[T any] struct {
+	path        string
+	createState func(userId botty.UserId, chatId botty.ChatId) T
+
+	m       sync.Mutex
+	content content[T]
+}
+
+var (
+	_ botty.UserManager             = (*Store[any])(nil)
+	_ botty.AppStateManager[any]    = (*Store[any])(nil)
+	_ botty.BatchSessionStorer[any] = (*Store[any])(nil)
+)
+
+// New creates a store persisted in the file at path, loading it if it exists.
+// createState creates the app state for new sessions.
+func New[T any](path string, createState func(userId botty.UserId, chatId botty.ChatId) T) (*Store[T], error) {
+	s := &Store[T]{
+		path:        path,
+		createState: createState,
+		content: content[T]{
+			Users:    make(map[botty.UserId]botty.User),
+			Sessions: make(map[botty.ChatId]botty.StoredSessionState[T]),
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading store %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.content); err != nil {
+		return nil, fmt.Errorf("error decoding store %s: %w", path, err)
+	}
+	if s.content.Users == nil {
+		s.content.Users = make(map[botty.UserId]botty.User)
+	}
+	if s.content.Sessions == nil {
+		s.content.Sessions = make(map[botty.ChatId]botty.StoredSessionState[T])
+	}
+	return s, nil
+}
+
+// update applies modify to a copy of the content and writes it. The content is only replaced
+// if writing succeeded, so a failed write doesn't leave changes that are not persisted.
+// Must be called with the lock held.
+func (s *Store[T]) update(modify func(c *content[T])) error {
+	c := content[T]{
+		Users:    maps.Clone(s.content.Users),
+		Sessions: maps.Clone(s.content.Sessions),
+	}
+	modify(&c)
+	if err := s.write(c); err != nil {
+		return err
+	}
+	s.content = c
+	return nil
+}
+
+// write stores the content to a temp file and replaces the store's file with it.
+func (s *Store[T]) write(c content[T]) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding store: %w: %w", botty.ErrStorePermanent, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error replacing store %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func (s *Store[T]) ListUsers() ([]botty.User, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	users := make([]botty.User, 0, len(s.content.Users))
+	for _, user := range s.content.Users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (s *Store[T]) AddUser(userID botty.UserId, userName string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.update(func(c *content[T]) {
+		c.Users[userID] = botty.User{ID: userID, Name: userName}
+	})
+}
+
+func (s *Store[T]) AddUserIfNotExists(userID botty.UserId, userName string) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.content.Users[userID]; ok {
+		return false, nil
+	}
+	err := s.update(func(c *content[T]) {
+		c.Users[userID] = botty.User{ID: userID, Name: userName}
+	})
+	return err == nil, err
+}
+
+func (s *Store[T]) UserExists(userID botty.UserId) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	_, ok := s.content.Users[userID]
+	return ok
+}
+
+func (s *Store[T]) DeleteUser(userID botty.UserId) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.update(func(c *content[T]) {
+		delete(c.Users, userID)
+	})
+}
+
+func (s *Store[T]) CreateAppState(userId botty.UserId, chatId botty.ChatId) T {
+	return s.createState(userId, chatId)
+}
+
+func (s *Store[T]) StoreSessionState(state botty.StoredSessionState[T]) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.update(func(c *content[T]) {
+		c.Sessions[state.ChatID] = state
+	})
+}
+
+// StoreSessionStates stores all states with a single write
+func (s *Store[T]) StoreSessionStates(states []botty.StoredSessionState[T]) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.update(func(c *content[T]) {
+		for _, state := range states {
+			c.Sessions[state.ChatID] = state
+		}
+	})
+}
+
+func (s *Store[T]) DeleteSessionState(userID botty.UserId, chatID botty.ChatId) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.update(func(c *content[T]) {
+		delete(c.Sessions, chatID)
+	})
+}
+
+func (s *Store[T]) LoadSessionStates() ([]botty.StoredSessionState[T], error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	states := make([]botty.StoredSessionState[T], 0, len(s.content.Sessions))
+	for _, state := range s.content.Sessions {
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -0,0 +1,103 @@
+package filestore
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/frairon/botty"
+)
+
+type appState struct {
+	Counter int
+	Tags    []string
+}
+
+func newAppState(userId botty.UserId, chatId botty.ChatId) appState {
+	return appState{Tags: []string{"new"}}
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := New(path, newAppState)
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	for id, name := range map[botty.UserId]string{1: "anna", 2: "bob", 3: "carl"} {
+		if err := store.AddUser(id, name); err != nil {
+			t.Fatalf("error adding user: %v", err)
+		}
+	}
+	if err := store.DeleteUser(3); err != nil {
+		t.Fatalf("error deleting user: %v", err)
+	}
+
+	states := []botty.StoredSessionState[appState]{
+		{
+			UserID:     1,
+			ChatID:     1,
+			LastAction: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+			Settings:   botty.SessionSettings{TimeZone: "Europe/Berlin"},
+			State:      appState{Counter: 42, Tags: []string{"a", "b"}},
+		},
+		{
+			UserID: 1,
+			ChatID: -100,
+			State:  store.CreateAppState(1, -100),
+		},
+	}
+	for _, state := range states {
+		if err := store.StoreSessionState(state); err != nil {
+			t.Fatalf("error storing session: %v", err)
+		}
+	}
+
+	reopened, err := New(path, newAppState)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	users, err := reopened.ListUsers()
+	if err != nil {
+		t.Fatalf("error listing users: %v", err)
+	}
+	if expected := []botty.User{{ID: 1, Name: "anna"}, {ID: 2, Name: "bob"}}; !slices.Equal(users, expected) {
+		t.Errorf("expected users %v, got %v", expected, users)
+	}
+	if !reopened.UserExists(2) || reopened.UserExists(3) {
+		t.Errorf("expected user 2 to exist and user 3 to be deleted")
+	}
+
+	loaded, err := reopened.LoadSessionStates()
+	if err != nil {
+		t.Fatalf("error loading sessions: %v", err)
+	}
+	slices.SortFunc(loaded, func(a, b botty.StoredSessionState[appState]) int { return int(b.ChatID - a.ChatID) })
+	if !reflect.DeepEqual(loaded, states) {
+		t.Errorf("sessions differ after round trip\nexpected: %+v\n     got: %+v", states, loaded)
+	}
+}
+
+func TestUnserializableStateIsPermanentError(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "store.json"), func(userId botty.UserId, chatId botty.ChatId) chan int {
+		return make(chan int)
+	})
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	err = store.StoreSessionState(botty.StoredSessionState[chan int]{UserID: 1, ChatID: 1, State: make(chan int)})
+	if !errors.Is(err, botty.ErrStorePermanent) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+
+	// the failed state is not kept, so it doesn't break the following writes
+	if err := store.AddUser(1, "user"); err != nil {
+		t.Errorf("error adding user after a failed write: %v", err)
+	}
+	if states, _ := store.LoadSessionStates(); len(states) != 0 {
+		t.Errorf("expected the failed state to be rolled back, got %d states", len(states))
+	}
+}
@@ -0,0 +1,91 @@
+package botty
+
+import (
+	"fmt"
+	"strings"
+)
+
+type formStep[T any] struct {
+	prompt string
+	parse  func(string) (any, error)
+	assign func(bs Session[T], v any)
+}
+
+// Form collects values in multiple steps. Each step prompts the user, parses the answer
+// (re-prompting on errors, like InputState) and assigns the parsed value.
+// /back returns to the previous step, or leaves the form in its first step.
+type Form[T any] struct {
+	steps      []formStep[T]
+	onComplete func(bs Session[T])
+}
+
+func NewForm[T any]() *Form[T] {
+	return &Form[T]{}
+}
+
+// Step adds a step to the form. See ParseInt, ParseFloat and ParseDate for common parsers.
+func (f *Form[T]) Step(prompt string, parse func(string) (any, error), assign func(bs Session[T], v any)) *Form[T] {
+	f.steps = append(f.steps, formStep[T]{
+		prompt: prompt,
+		parse:  parse,
+		assign: assign,
+	})
+	return f
+}
+
+// OnComplete is called after the last step has been assigned. The form pops itself afterwards.
+func (f *Form[T]) OnComplete(onComplete func(bs Session[T])) *Form[T] {
+	f.onComplete = onComplete
+	return f
+}
+
+func (f *Form[T]) Build() State[T] {
+	var current int
+
+	prompt := func(bs Session[T]) {
+		bs.SendMessage(fmt.Sprintf("%s (%d/%d)", f.steps[current].prompt, current+1, len(f.steps)))
+	}
+
+	return &functionState[T]{
+		activate: func(bs Session[T]) {
+			current = 0
+			if len(f.steps) == 0 {
+				if f.onComplete != nil {
+					f.onComplete(bs)
+				}
+				bs.PopState()
+				return
+			}
+			prompt(bs)
+		},
+		returner: prompt,
+		handleMessage: func(bs Session[T], msg ChatMessage) {
+			step := f.steps[current]
+			value, err := step.parse(strings.TrimSpace(msg.Text()))
+			if err != nil {
+				bs.SendMessage(fmt.Sprintf("Invalid value: %v. Enter valid value.", err))
+				return
+			}
+			step.assign(bs, value)
+
+			current++
+			if current < len(f.steps) {
+				prompt(bs)
+				return
+			}
+
+			if f.onComplete != nil {
+				f.onComplete(bs)
+			}
+			bs.PopState()
+		},
+		commandHandler: func(bs Session[T], command string, args ...string) bool {
+			if command != CommandCancel.Command || current == 0 {
+				return false
+			}
+			current--
+			prompt(bs)
+			return true
+		},
+	}
+}
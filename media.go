@@ -0,0 +1,175 @@
+package botty
+
+import (
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// FileSource references a file to be sent
+type FileSource struct {
+	data tgbotapi.RequestFileData
+}
+
+// FileFromPath uploads a local file
+func FileFromPath(path string) FileSource {
+	return FileSource{data: tgbotapi.FilePath(path)}
+}
+
+// FileFromURL lets telegram download the file from the URL
+func FileFromURL(url string) FileSource {
+	return FileSource{data: tgbotapi.FileURL(url)}
+}
+
+// FileFromID references a file that has been uploaded to telegram before
+func FileFromID(fileId string) FileSource {
+	return FileSource{data: tgbotapi.FileID(fileId)}
+}
+
+// FileFromBytes uploads the data as a file with the given name
+func FileFromBytes(name string, data []byte) FileSource {
+	return FileSource{data: tgbotapi.FileBytes{Name: name, Bytes: data}}
+}
+
+type MediaType int
+
+const (
+	MediaPhoto MediaType = iota
+	MediaVideo
+	MediaDocument
+	MediaAnimation
+)
+
+// MediaItem is a media file with caption, e.g. to replace the media of a message
+type MediaItem struct {
+	Type    MediaType
+	Source  FileSource
+	Caption string
+}
+
+func (mi MediaItem) inputMedia() (interface{}, error) {
+	switch mi.Type {
+	case MediaPhoto:
+		media := tgbotapi.NewInputMediaPhoto(mi.Source.data)
+		media.Caption = mi.Caption
+		media.ParseMode = "html"
+		return media, nil
+	case MediaVideo:
+		media := tgbotapi.NewInputMediaVideo(mi.Source.data)
+		media.Caption = mi.Caption
+		media.ParseMode = "html"
+		return media, nil
+	case MediaDocument:
+		media := tgbotapi.NewInputMediaDocument(mi.Source.data)
+		media.Caption = mi.Caption
+		media.ParseMode = "html"
+		return media, nil
+	case MediaAnimation:
+		// the library cannot upload InputMediaAnimation, so it's sent as document of type animation
+		media := tgbotapi.NewInputMediaDocument(mi.Source.data)
+		media.Type = "animation"
+		media.Caption = mi.Caption
+		media.ParseMode = "html"
+		return media, nil
+	default:
+		return nil, fmt.Errorf("unsupported media type %d", mi.Type)
+	}
+}
+
+func (bs *session[T]) SendMedia(media MediaItem, opts ...SendMessageOption) Message {
+	var (
+		config   tgbotapi.Chattable
+		baseChat *tgbotapi.BaseChat
+	)
+	switch media.Type {
+	case MediaPhoto:
+		photo := tgbotapi.NewPhoto(int64(bs.chatId), media.Source.data)
+		photo.Caption, photo.ParseMode = media.Caption, "html"
+		baseChat, config = &photo.BaseChat, &photo
+	case MediaVideo:
+		video := tgbotapi.NewVideo(int64(bs.chatId), media.Source.data)
+		video.Caption, video.ParseMode = media.Caption, "html"
+		baseChat, config = &video.BaseChat, &video
+	case MediaDocument:
+		document := tgbotapi.NewDocument(int64(bs.chatId), media.Source.data)
+		document.Caption, document.ParseMode = media.Caption, "html"
+		baseChat, config = &document.BaseChat, &document
+	case MediaAnimation:
+		animation := tgbotapi.NewAnimation(int64(bs.chatId), media.Source.data)
+		animation.Caption, animation.ParseMode = media.Caption, "html"
+		baseChat, config = &animation.BaseChat, &animation
+	default:
+		log.Printf("error sending media: unsupported media type %d", media.Type)
+		return &message{}
+	}
+
+	return bs.sendChattable(config, baseChat, opts...)
+}
+
+func (bs *session[T]) SendAnimation(src FileSource, caption string, opts ...SendMessageOption) Message {
+	return bs.SendMedia(MediaItem{Type: MediaAnimation, Source: src, Caption: caption}, opts...)
+}
+
+func (bs *session[T]) SendVoice(src FileSource, opts ...SendMessageOption) Message {
+	voice := tgbotapi.NewVoice(int64(bs.chatId), src.data)
+	return bs.sendChattable(&voice, &voice.BaseChat, opts...)
+}
+
+func (bs *session[T]) SendAudio(src FileSource, title, performer string, opts ...SendMessageOption) Message {
+	audio := tgbotapi.NewAudio(int64(bs.chatId), src.data)
+	audio.Title, audio.Performer = title, performer
+	return bs.sendChattable(&audio, &audio.BaseChat, opts...)
+}
+
+// sendChattable applies the options to baseChat, which must belong to config, and sends it.
+func (bs *session[T]) sendChattable(config tgbotapi.Chattable, baseChat *tgbotapi.BaseChat, opts ...SendMessageOption) Message {
+	if err := bs.applySendOptions(baseChat, opts...); err != nil {
+		log.Printf("Error sending %T: %v", config, err)
+		return &message{}
+	}
+
+	sentMsg, err := bs.botApi.Send(config)
+	if err != nil {
+		log.Printf("Error sending %T: %v", config, err)
+		return &message{messageId: sentMsg.MessageID}
+	}
+	bs.bot.messagesSent.Add(1)
+	bs.trackInlineMessage(MessageId(sentMsg.MessageID), baseChat.ReplyMarkup, newSendMessageOptions(opts...).ownerOnly)
+	return &message{messageId: sentMsg.MessageID, editor: bs}
+}
+
+func (bs *session[T]) UpdateMessageMedia(messageId MessageId, media MediaItem, keyboard InlineKeyboard) Message {
+	inputMedia, err := media.inputMedia()
+	if err != nil {
+		log.Printf("error updating message media: %v", err)
+		return &message{messageId: int(messageId)}
+	}
+
+	markup, webApp, err := editMarkup(keyboard)
+	if err != nil {
+		log.Printf("error updating message media: %v", err)
+		return &message{messageId: int(messageId)}
+	}
+
+	edit := tgbotapi.EditMessageMediaConfig{
+		BaseEdit: tgbotapi.BaseEdit{
+			ChatID:      int64(bs.chatId),
+			MessageID:   int(messageId),
+			ReplyMarkup: markup,
+		},
+		Media: inputMedia,
+	}
+	if _, err := bs.botApi.Request(edit); err != nil {
+		log.Printf("error updating message media: %v", err)
+		return &message{messageId: int(messageId), editor: bs}
+	}
+
+	// the media edit cannot contain web app buttons, so the keyboard is set afterwards
+	if webApp {
+		if err := bs.editWebAppKeyboard(messageId, keyboard, "", ""); err != nil {
+			log.Printf("error updating message media keyboard: %v", err)
+		}
+	}
+	return &message{messageId: int(messageId), editor: bs}
+}
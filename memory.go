@@ -0,0 +1,115 @@
+package botty
+
+import (
+	"sort"
+	"sync"
+)
+
+type inMemoryUserManager struct {
+	m     sync.Mutex
+	users map[UserId]User
+}
+
+// NewInMemoryUserManager creates a UserManager keeping users in memory, e.g. for use with MockBot.
+func NewInMemoryUserManager() UserManager {
+	return &inMemoryUserManager{
+		users: make(map[UserId]User),
+	}
+}
+
+func (um *inMemoryUserManager) ListUsers() ([]User, error) {
+	um.m.Lock()
+	defer um.m.Unlock()
+
+	users := make([]User, 0, len(um.users))
+	for _, user := range um.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (um *inMemoryUserManager) AddUser(userID UserId, userName string) error {
+	um.m.Lock()
+	defer um.m.Unlock()
+	um.users[userID] = User{ID: userID, Name: userName}
+	return nil
+}
+
+func (um *inMemoryUserManager) AddUserIfNotExists(userID UserId, userName string) (bool, error) {
+	um.m.Lock()
+	defer um.m.Unlock()
+	if _, ok := um.users[userID]; ok {
+		return false, nil
+	}
+	um.users[userID] = User{ID: userID, Name: userName}
+	return true, nil
+}
+
+func (um *inMemoryUserManager) UserExists(userID UserId) bool {
+	um.m.Lock()
+	defer um.m.Unlock()
+	_, ok := um.users[userID]
+	return ok
+}
+
+func (um *inMemoryUserManager) DeleteUser(userID UserId) error {
+	um.m.Lock()
+	defer um.m.Unlock()
+	delete(um.users, userID)
+	return nil
+}
+
+type inMemoryAppStateManager[T any] struct {
+	m      sync.Mutex
+	states map[ChatId]StoredSessionState[T]
+}
+
+// NewInMemoryAppStateManager creates an AppStateManager keeping session states in memory,
+// e.g. for use with MockBot. New sessions get the zero value of T as app state.
+func NewInMemoryAppStateManager[T any]() AppStateManager[T] {
+	return &inMemoryAppStateManager[T]{
+		states: make(map[ChatId]StoredSessionState[T]),
+	}
+}
+
+func (sm *inMemoryAppStateManager[T]) CreateAppState(userId UserId, chatId ChatId) T {
+	var state T
+	return state
+}
+
+func (sm *inMemoryAppStateManager[T]) StoreSessionState(state StoredSessionState[T]) error {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	sm.states[state.ChatID] = state
+	return nil
+}
+
+func (sm *inMemoryAppStateManager[T]) StoreSessionStates(states []StoredSessionState[T]) error {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	for _, state := range states {
+		sm.states[state.ChatID] = state
+	}
+	return nil
+}
+
+func (sm *inMemoryAppStateManager[T]) DeleteSessionState(userID UserId, chatID ChatId) error {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	delete(sm.states, chatID)
+	return nil
+}
+
+func (sm *inMemoryAppStateManager[T]) LoadSessionStates() ([]StoredSessionState[T], error) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+
+	states := make([]StoredSessionState[T], 0, len(sm.states))
+	for _, state := range sm.states {
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -1,23 +1,232 @@
 package botty
 
-import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxMessageLength is the maximum number of characters of a message's text, as defined by telegram
+const MaxMessageLength = 4096
+
+// splitMessage splits the html-formatted text into parts of at most limit characters,
+// preferring to split at newlines. It never splits inside a tag or entity. Tags that are
+// open at a split are closed at the end of the part and reopened in the next one.
+// Always returns at least one part.
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > limit {
+		cut, open := htmlSplitPoint(text, limit)
+		parts = append(parts, text[:cut]+closeTags(open))
+		text = strings.Join(open, "") + strings.TrimPrefix(text[cut:], "\n")
+	}
+	return append(parts, text)
+}
+
+// htmlSplitPoint finds the byte offset to split the text at and the tags open at that offset.
+func htmlSplitPoint(text string, limit int) (int, []string) {
+	var (
+		open         []string
+		tagStart     = -1
+		inEntity     bool
+		count        int
+		cut, newline int
+		cutOpen      []string
+		newlineOpen  []string
+		hardCut      = len(text)
+	)
+	for pos, r := range text {
+		if count == limit {
+			hardCut = pos
+		}
+		if tagStart < 0 && !inEntity && pos > 0 {
+			if count+len(closeTags(open)) > limit {
+				break
+			}
+			cut, cutOpen = pos, append([]string(nil), open...)
+			if r == '\n' {
+				newline, newlineOpen = cut, cutOpen
+			}
+		}
+
+		switch {
+		case tagStart >= 0:
+			if r == '>' {
+				open = trackTag(open, text[tagStart:pos+1])
+				tagStart = -1
+			}
+		case r == '<':
+			tagStart = pos
+		case r == '&':
+			inEntity = true
+		case inEntity && (r == ';' || unicode.IsSpace(r)):
+			inEntity = false
+		}
+		count++
+	}
+
+	switch {
+	case newline > 0:
+		return newline, newlineOpen
+	case cut > 0:
+		return cut, cutOpen
+	default:
+		// no safe split point, e.g. a single huge tag
+		return hardCut, nil
+	}
+}
+
+// trackTag pushes an opening tag or pops the matching closing tag
+func trackTag(open []string, tag string) []string {
+	name := tagName(tag)
+	if !strings.HasPrefix(tag, "</") {
+		return append(open, tag)
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		if tagName(open[i]) == name {
+			return append(open[:i:i], open[i+1:]...)
+		}
+	}
+	return open
+}
+
+func tagName(tag string) string {
+	fields := strings.Fields(strings.Trim(tag, "</>"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+func closeTags(open []string) string {
+	var closing strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		closing.WriteString("</" + tagName(open[i]) + ">")
+	}
+	return closing.String()
+}
 
 type ChatMessage interface {
 	Text() string
+	MessageID() MessageId
+
+	// Date returns when the message was sent, e.g. to ignore messages sent while the bot was down
+	Date() time.Time
+
+	// Entities returns the special entities in the text, like mentions, URLs or hashtags
+	Entities() []MessageEntity
+	// Mentions returns the @usernames mentioned in the text
+	Mentions() []string
+
+	// Contact returns the contact shared by the user, nil if the message has none
+	Contact() *Contact
+	// Location returns the location shared by the user, nil if the message has none
+	Location() *Location
+}
+
+type Contact struct {
+	PhoneNumber string
+	FirstName   string
+	LastName    string
+	// set if the contact is a telegram user
+	UserID UserId
+}
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// MessageEntity is a special entity in a message text, e.g. a mention, URL or hashtag.
+// Offset and Length are counted in UTF-16 code units, as telegram does.
+type MessageEntity struct {
+	Type   string
+	Offset int
+	Length int
+	URL    string
 }
 
 type tgMessage struct {
 	m *tgbotapi.Message
 }
 
+func (m *tgMessage) Date() time.Time {
+	return m.m.Time()
+}
+
 func (m *tgMessage) Text() string {
 	return m.m.Text
 }
 
+func (m *tgMessage) MessageID() MessageId {
+	return MessageId(m.m.MessageID)
+}
+
+func (m *tgMessage) Entities() []MessageEntity {
+	entities := make([]MessageEntity, 0, len(m.m.Entities))
+	for _, entity := range m.m.Entities {
+		entities = append(entities, MessageEntity{
+			Type:   entity.Type,
+			Offset: entity.Offset,
+			Length: entity.Length,
+			URL:    entity.URL,
+		})
+	}
+	return entities
+}
+
+func (m *tgMessage) Contact() *Contact {
+	if m.m.Contact == nil {
+		return nil
+	}
+	return &Contact{
+		PhoneNumber: m.m.Contact.PhoneNumber,
+		FirstName:   m.m.Contact.FirstName,
+		LastName:    m.m.Contact.LastName,
+		UserID:      UserId(m.m.Contact.UserID),
+	}
+}
+
+func (m *tgMessage) Location() *Location {
+	if m.m.Location == nil {
+		return nil
+	}
+	return &Location{
+		Latitude:  m.m.Location.Latitude,
+		Longitude: m.m.Location.Longitude,
+	}
+}
+
+func (m *tgMessage) Mentions() []string {
+	text := utf16.Encode([]rune(m.m.Text))
+
+	var mentions []string
+	for _, entity := range m.m.Entities {
+		if !entity.IsMention() || entity.Offset < 0 || entity.Offset+entity.Length > len(text) {
+			continue
+		}
+		mentions = append(mentions, string(utf16.Decode(text[entity.Offset:entity.Offset+entity.Length])))
+	}
+	return mentions
+}
+
 type CallbackQuery interface {
 	Data() string
 	ID() string
+	// MessageID returns the ID of the message the button belongs to, 0 for inline messages
 	MessageID() MessageId
+	// InlineMessageID returns the ID of the inline message the button belongs to,
+	// i.e. a message sent via inline mode, empty otherwise
+	InlineMessageID() string
+
+	// user who pressed the button
+	From() UserId
+	// text of the message the button belongs to
+	MessageText() string
 }
 
 type tgCbQuery struct {
@@ -37,3 +246,56 @@ func (m *tgCbQuery) MessageID() MessageId {
 	return 0
 
 }
+
+func (m *tgCbQuery) InlineMessageID() string {
+	return m.m.InlineMessageID
+}
+
+func (m *tgCbQuery) From() UserId {
+	if m.m.From != nil {
+		return UserId(m.m.From.ID)
+	}
+	return 0
+}
+
+func (m *tgCbQuery) MessageText() string {
+	if m.m.Message != nil {
+		return m.m.Message.Text
+	}
+	return ""
+}
+
+// PollAnswer is a user's answer to a poll
+type PollAnswer struct {
+	PollID string
+	UserID UserId
+	// indexes of the chosen options, empty if the user retracted the vote
+	OptionIDs []int
+}
+
+// InlineQuery is a query sent to the bot in inline mode
+type InlineQuery struct {
+	ID     string
+	From   UserId
+	Query  string
+	Offset string
+}
+
+// InlineResult is an article answering an inline query. Text is sent as HTML when the result is chosen.
+type InlineResult struct {
+	ID          string
+	Title       string
+	Description string
+	Text        string
+}
+
+// UpdateInfo describes an update that was not handled
+type UpdateInfo struct {
+	UpdateID int
+	// set if the update is a (possibly edited) message
+	Message ChatMessage
+	// set if the message is a command
+	Command string
+	// set if the update is a callback query
+	CallbackQuery CallbackQuery
+}
@@ -0,0 +1,99 @@
+package botty
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// checkParts asserts that each part fits the limit and contains complete, balanced tags
+func checkParts(t *testing.T, parts []string, limit int) {
+	t.Helper()
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > limit {
+			t.Errorf("part %d has %d characters, exceeds %d", i, n, limit)
+		}
+		var open []string
+		for _, tag := range tagPattern.FindAllString(part, -1) {
+			open = trackTag(open, tag)
+		}
+		if len(open) > 0 {
+			t.Errorf("part %d has unclosed tags %v", i, open)
+		}
+		if withoutTags := tagPattern.ReplaceAllString(part, ""); strings.ContainsAny(withoutTags, "<>") {
+			t.Errorf("part %d contains a split tag", i)
+		}
+	}
+}
+
+func TestSplitMessageBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		length int
+		parts  int
+	}{
+		{length: MaxMessageLength - 1, parts: 1},
+		{length: MaxMessageLength, parts: 1},
+		{length: MaxMessageLength + 1, parts: 2},
+		{length: 2 * MaxMessageLength, parts: 2},
+		{length: 2*MaxMessageLength + 1, parts: 3},
+	} {
+		text := strings.Repeat("ä", tc.length)
+		parts := splitMessage(text, MaxMessageLength)
+		if len(parts) != tc.parts {
+			t.Errorf("%d characters: expected %d parts, got %d", tc.length, tc.parts, len(parts))
+		}
+		checkParts(t, parts, MaxMessageLength)
+		if joined := strings.Join(parts, ""); joined != text {
+			t.Errorf("%d characters: text was modified by splitting", tc.length)
+		}
+	}
+}
+
+func TestSplitMessagePrefersNewlines(t *testing.T) {
+	line := strings.Repeat("x", 999) + "\n"
+	text := strings.Repeat(line, 5)
+
+	parts := splitMessage(text, MaxMessageLength)
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+	checkParts(t, parts, MaxMessageLength)
+	if parts[0] != strings.TrimSuffix(strings.Repeat(line, 4), "\n") {
+		t.Errorf("expected the first part to end at the last fitting newline")
+	}
+}
+
+func TestSplitMessageHTML(t *testing.T) {
+	// 5000 characters of bold spans and entities, without newlines
+	var text strings.Builder
+	for text.Len() < 5000 {
+		text.WriteString(`<b>bold &amp; fat</b> <a href="https://example.com">link</a> `)
+	}
+
+	parts := splitMessage(text.String(), MaxMessageLength)
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+	checkParts(t, parts, MaxMessageLength)
+	for i, part := range parts {
+		if strings.Count(part, "&") != strings.Count(part, "&amp;") {
+			t.Errorf("part %d contains a split entity", i)
+		}
+	}
+}
+
+func TestSplitMessageReopensTags(t *testing.T) {
+	text := "<b>" + strings.Repeat("x", 30) + "</b>"
+
+	parts := splitMessage(text, 20)
+	checkParts(t, parts, 20)
+	if len(parts) < 2 || !strings.HasPrefix(parts[1], "<b>") {
+		t.Errorf("expected the open tag to be reopened in the next part, got %q", parts)
+	}
+	if joined := tagPattern.ReplaceAllString(strings.Join(parts, ""), ""); joined != strings.Repeat("x", 30) {
+		t.Errorf("text was modified by splitting: %q", joined)
+	}
+}
@@ -2,8 +2,12 @@ package botty
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -17,7 +21,22 @@ type MockBot[T any] struct {
 	api *mockApi[T]
 
 	LastMessage tgbotapi.MessageConfig
-	NumMsgSent  int
+	// ID of the last sent message, assigned incrementally by the mock
+	LastMessageID int
+	NumMsgSent    int
+
+	sent struct {
+		sync.Mutex
+		messages []tgbotapi.MessageConfig
+		requests []tgbotapi.Chattable
+	}
+
+	// channels of updates sent by SendAndWait, closed when the bot handled them
+	waiting struct {
+		sync.Mutex
+		lastUpdateID int
+		updates      map[int]chan struct{}
+	}
 
 	err struct {
 		sync.Mutex
@@ -25,6 +44,10 @@ type MockBot[T any] struct {
 	}
 }
 
+// NewMockBot creates and runs a bot using a mocked telegram API. The config's Connect is overwritten.
+// For tests, the config can be created with in-memory managers:
+//
+//	cfg := NewConfig[T]("", NewInMemoryAppStateManager[T](), NewInMemoryUserManager(), rootState)
 func NewMockBot[T any](cfg *Config[T]) (*MockBot[T], error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -48,6 +71,7 @@ func NewMockBot[T any](cfg *Config[T]) (*MockBot[T], error) {
 	if err != nil {
 		return nil, err
 	}
+	mockBot.bot.updateHandled = mockBot.updateHandled
 
 	go func() {
 		mockBot.err.Lock()
@@ -55,6 +79,12 @@ func NewMockBot[T any](cfg *Config[T]) (*MockBot[T], error) {
 		defer close(mockBot.done)
 		mockBot.err.err = mockBot.bot.Run(ctx)
 	}()
+
+	// wait until the loop handles updates, so e.g. CreateSession runs on it
+	select {
+	case mockBot.api.updates <- tgbotapi.Update{UpdateID: -1}:
+	case <-mockBot.done:
+	}
 	return mockBot, nil
 }
 
@@ -70,6 +100,9 @@ type mockApi[T any] struct {
 	mock *MockBot[T]
 
 	updates chan tgbotapi.Update
+
+	// sessions send concurrently, e.g. from ForeachSessionAsync
+	mSend sync.Mutex
 }
 
 func (mb *MockBot[T]) Stop() {
@@ -78,10 +111,18 @@ func (mb *MockBot[T]) Stop() {
 }
 
 func (mb *MockBot[T]) CreateSession(userId UserId) (Session[T], error) {
-	chatId := ChatId(userId)
-	var err error
-	mb.bot.sessions[chatId], err = mb.bot.getOrCreateSession(context.Background(), userId, chatId)
-	return mb.bot.sessions[chatId], err
+	var (
+		session *session[T]
+		err     error
+	)
+	// created on the loop, as activating the root state sends messages like a handler
+	mb.bot.runOnLoop(func() {
+		session, err = mb.bot.getOrCreateSession(context.Background(), userId, ChatId(userId))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
 }
 
 func (mb *MockBot[T]) LastMessageText() string {
@@ -102,14 +143,135 @@ func (mb *MockBot[T]) LastMessageButtons() []string {
 	return buttons
 }
 
+// SentMessages returns all text messages sent by the bot, oldest first
+func (mb *MockBot[T]) SentMessages() []tgbotapi.MessageConfig {
+	mb.sent.Lock()
+	defer mb.sent.Unlock()
+	return slices.Clone(mb.sent.messages)
+}
+
+// SentTexts returns the texts of all messages sent by the bot, oldest first
+func (mb *MockBot[T]) SentTexts() []string {
+	mb.sent.Lock()
+	defer mb.sent.Unlock()
+	texts := make([]string, 0, len(mb.sent.messages))
+	for _, msg := range mb.sent.messages {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+// ClearSentMessages forgets the sent messages, e.g. to assert only the messages of the next step
+func (mb *MockBot[T]) ClearSentMessages() {
+	mb.sent.Lock()
+	defer mb.sent.Unlock()
+	mb.sent.messages = nil
+}
+
+// LastMessageInlineButtons returns the inline buttons of the last sent message
+func (mb *MockBot[T]) LastMessageInlineButtons() []InlineButton {
+	keyboard, ok := mb.LastMessage.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok {
+		return nil
+	}
+	var buttons []InlineButton
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			var data string
+			if button.CallbackData != nil {
+				data = *button.CallbackData
+			}
+			buttons = append(buttons, NewInlineButton(button.Text, data))
+		}
+	}
+	return buttons
+}
+
+// Requests returns the requests sent to the API that don't send a message, e.g. edits
+func (mb *MockBot[T]) Requests() []tgbotapi.Chattable {
+	mb.sent.Lock()
+	defer mb.sent.Unlock()
+	return slices.Clone(mb.sent.requests)
+}
+
+// Send sends the text as the user. It returns after the bot received the following noop update,
+// which is kept for compatibility, prefer SendAndWait.
 func (mb *MockBot[T]) Send(userId UserId, text string) {
-	mb.api.updates <- tgbotapi.Update{
-		Message: &tgbotapi.Message{
+	mb.sendUpdate(tgbotapi.Update{
+		Message: mockMessage(userId, text),
+	})
+}
+
+// SendAndWait sends the text as the user and returns when the bot has handled it completely.
+// This is the preferred way to send messages in tests.
+func (mb *MockBot[T]) SendAndWait(userId UserId, text string) {
+	mb.waiting.Lock()
+	if mb.waiting.updates == nil {
+		mb.waiting.updates = map[int]chan struct{}{}
+	}
+	mb.waiting.lastUpdateID++
+	updateID := mb.waiting.lastUpdateID
+	handled := make(chan struct{})
+	mb.waiting.updates[updateID] = handled
+	mb.waiting.Unlock()
+
+	select {
+	case mb.api.updates <- tgbotapi.Update{UpdateID: updateID, Message: mockMessage(userId, text)}:
+	case <-mb.done:
+		return
+	}
+
+	select {
+	case <-handled:
+	case <-mb.done:
+	}
+}
+
+// updateHandled is called by the bot after handling an update
+func (mb *MockBot[T]) updateHandled(updateID int) {
+	mb.waiting.Lock()
+	defer mb.waiting.Unlock()
+	if handled, ok := mb.waiting.updates[updateID]; ok {
+		close(handled)
+		delete(mb.waiting.updates, updateID)
+	}
+}
+
+func mockMessage(userId UserId, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		From: &tgbotapi.User{ID: int64(userId)},
+		Chat: &tgbotapi.Chat{ID: int64(userId)},
+		Date: int(time.Now().Unix()),
+		Text: text,
+	}
+}
+
+// SendCommand sends the command with its arguments, e.g. SendCommand(user, "home")
+func (mb *MockBot[T]) SendCommand(userId UserId, command string, args ...string) {
+	msg := mockMessage(userId, "/"+strings.Join(append([]string{command}, args...), " "))
+	msg.Entities = []tgbotapi.MessageEntity{
+		{Type: "bot_command", Offset: 0, Length: len(command) + 1},
+	}
+	mb.sendUpdate(tgbotapi.Update{Message: msg})
+}
+
+// PressInline simulates pressing an inline button with data on the message
+func (mb *MockBot[T]) PressInline(userId UserId, messageId int, data string) {
+	mb.sendUpdate(tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   fmt.Sprintf("query-%d", messageId),
 			From: &tgbotapi.User{ID: int64(userId)},
-			Chat: &tgbotapi.Chat{ID: int64(userId)},
-			Text: text,
+			Message: &tgbotapi.Message{
+				MessageID: messageId,
+				Chat:      &tgbotapi.Chat{ID: int64(userId)},
+			},
+			Data: data,
 		},
-	}
+	})
+}
+
+func (mb *MockBot[T]) sendUpdate(update tgbotapi.Update) {
+	mb.api.updates <- update
 	// send noop update to synchronize the caller
 	mb.api.updates <- tgbotapi.Update{
 		UpdateID: -1,
@@ -117,10 +279,16 @@ func (mb *MockBot[T]) Send(userId UserId, text string) {
 }
 
 func (m *mockApi[T]) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	m.mock.sent.Lock()
+	m.mock.sent.requests = append(m.mock.sent.requests, c)
+	m.mock.sent.Unlock()
+
 	switch value := c.(type) {
 
 	// ignored
-	case tgbotapi.SetMyCommandsConfig:
+	case tgbotapi.SetMyCommandsConfig, tgbotapi.ChatActionConfig,
+		tgbotapi.PinChatMessageConfig, tgbotapi.UnpinChatMessageConfig,
+		tgbotapi.CallbackConfig, tgbotapi.EditMessageTextConfig, tgbotapi.EditMessageReplyMarkupConfig:
 	default:
 		_ = value
 
@@ -129,16 +297,22 @@ func (m *mockApi[T]) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error
 	return nil, nil
 }
 func (m *mockApi[T]) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m.mSend.Lock()
+	defer m.mSend.Unlock()
 	// log.Printf("Send: %#v", c)
 	switch value := c.(type) {
 	case (tgbotapi.MessageConfig):
 		m.mock.LastMessage = value
+		m.mock.LastMessageID = m.mock.NumMsgSent + 1
+		m.mock.sent.Lock()
+		m.mock.sent.messages = append(m.mock.sent.messages, value)
+		m.mock.sent.Unlock()
 
 	default:
 		log.Printf("Trying to send something unknown: %T", c)
 	}
 	m.mock.NumMsgSent++
-	return tgbotapi.Message{}, nil
+	return tgbotapi.Message{MessageID: m.mock.NumMsgSent}, nil
 }
 func (m *mockApi[T]) GetMe() (tgbotapi.User, error) {
 	return tgbotapi.User{
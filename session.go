@@ -2,9 +2,14 @@ package botty
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -17,55 +22,254 @@ type GlobalMessageHandler[T any] func(bs Session[T], message *tgbotapi.Message)
 type Message interface {
 	UpdateMessage(queryId string, text string, opts ...SendMessageOption)
 	RemoveKeyboardForMessage()
+	// UpdateCaption replaces the caption of a media message
+	UpdateCaption(text string)
+	// UpdateKeyboard replaces the message's inline keyboard
+	UpdateKeyboard(keyboard InlineKeyboard)
 	ID() int
+
+	// returns the poll's ID if the message is a poll, empty string otherwise
+	PollID() string
 }
 
 type message struct {
 	messageId int // use this in the state
-	// if we add a bot-session, do not marshal that to state but inject when unmarshalling
+
+	// set if the message is a poll
+	pollId string
+
+	// editor used to modify the message, nil if the message was not sent successfully.
+	// Not to be marshalled, if messages are ever stored.
+	editor messageEditor
+}
+
+// messageEditor is implemented by the session to modify sent messages
+type messageEditor interface {
+	updateMessage(messageId MessageId, text string, opts ...SendMessageOption)
+	updateCaption(messageId MessageId, text string)
+	updateKeyboard(messageId MessageId, keyboard InlineKeyboard)
+	answerCallback(queryId string)
+	RemoveKeyboardForMessage(messageId MessageId)
 }
 
 func (m *message) UpdateMessage(queryId string, text string, opts ...SendMessageOption) {
+	if m.editor == nil {
+		return
+	}
+	m.editor.updateMessage(MessageId(m.messageId), text, opts...)
+	if queryId != "" {
+		m.editor.answerCallback(queryId)
+	}
 }
 func (m *message) RemoveKeyboardForMessage() {
+	if m.editor == nil {
+		return
+	}
+	m.editor.RemoveKeyboardForMessage(MessageId(m.messageId))
+}
+
+func (m *message) UpdateCaption(text string) {
+	if m.editor == nil {
+		return
+	}
+	m.editor.updateCaption(MessageId(m.messageId), text)
+}
+
+func (m *message) UpdateKeyboard(keyboard InlineKeyboard) {
+	if m.editor == nil {
+		return
+	}
+	m.editor.updateKeyboard(MessageId(m.messageId), keyboard)
 }
 
 func (m *message) ID() int {
 	return m.messageId
 }
 
+func (m *message) PollID() string {
+	return m.pollId
+}
+
+// ProgressReporter shows the progress of a long running operation by editing a single message
+type ProgressReporter interface {
+	// Update replaces the progress message's text
+	Update(text string)
+	// Done replaces the progress message's text with a final text
+	Done(text string)
+}
+
+type progressReporter struct {
+	msg Message
+}
+
+func (p *progressReporter) Update(text string) {
+	p.msg.UpdateMessage("", "⏳ "+text)
+}
+
+func (p *progressReporter) Done(text string) {
+	p.msg.UpdateMessage("", "✅ "+text)
+}
+
 type Session[T any] interface {
 	SendMessage(text string, opts ...SendMessageOption) Message
 	SendTemplateMessage(template string, values KeyValues, opts ...SendMessageOption) Message
+	// TrySendMessage is like SendMessage but returns the error instead of logging it,
+	// e.g. to stop a broadcast when the user blocked the bot.
+	TrySendMessage(text string, opts ...SendMessageOption) (Message, error)
 	UpdateMessageForCallback(queryId string, messageId MessageId, text string, opts ...SendMessageOption)
 
+	// AnswerCallbackQuery answers a callback query with a notification, or an alert if alert is set.
+	AnswerCallbackQuery(queryId string, text string, alert bool)
+	// InlineMessageOwner returns the user whose update triggered sending the recently sent message
+	// with inline keyboard. In group chats, that's not necessarily the session's user.
+	InlineMessageOwner(messageId MessageId) (UserId, bool)
+
+	// SetStateKeyboard sets the keyboard of the current state, which will be sent with
+	// all messages that do not set their own keyboard. It is cleared when the state is left.
+	SetStateKeyboard(keyboard Keyboard)
+
+	// SendPoll sends a poll. Answers are passed to the config's PollAnswerHandler.
+	SendPoll(question string, options []string, cfg PollConfig) Message
+
+	// SendVenue sends a location with title and address
+	SendVenue(lat, lon float64, title, address string, opts ...SendMessageOption) Message
+	// SendContact sends a contact card
+	SendContact(phone, firstName string, opts ...SendMessageOption) Message
+
+	// PinMessage pins the message in the chat. In groups, the bot needs the right to pin messages.
+	PinMessage(messageId MessageId, silent bool)
+	UnpinMessage(messageId MessageId)
+
+	// RestrictUser mutes the user in the session's group for the duration, 0 meaning forever.
+	// The bot needs the admin right to restrict members.
+	RestrictUser(userId UserId, duration time.Duration) error
+	// BanUser removes the user from the session's group for the duration, 0 meaning forever.
+	// The bot needs the admin right to ban members.
+	BanUser(userId UserId, duration time.Duration) error
+	// UnbanUser lets a banned user join the group again
+	UnbanUser(userId UserId) error
+
+	// SendDice sends an animated dice and returns the rolled value. Empty emoji defaults to 🎲,
+	// see DiceEmojis for the supported ones.
+	SendDice(emoji string) (Message, int)
+
+	// SendMedia sends a photo, video, document or animation
+	SendMedia(media MediaItem, opts ...SendMessageOption) Message
+	// SendAnimation sends a GIF or a soundless video
+	SendAnimation(src FileSource, caption string, opts ...SendMessageOption) Message
+	// SendVoice sends a voice message, which must be OGG encoded with OPUS
+	SendVoice(src FileSource, opts ...SendMessageOption) Message
+	// SendAudio sends a music file shown in the player with title and performer
+	SendAudio(src FileSource, title, performer string, opts ...SendMessageOption) Message
+	// UpdateMessageMedia replaces the media of a photo, video, document or animation message and its inline keyboard
+	UpdateMessageMedia(messageId MessageId, media MediaItem, keyboard InlineKeyboard) Message
+
+	// ForwardMessage forwards a message between chats
+	ForwardMessage(toChat ChatId, fromChat ChatId, messageId MessageId) Message
+	// CopyMessage copies a message between chats without a link to the original and returns the copy's ID
+	CopyMessage(toChat ChatId, fromChat ChatId, messageId MessageId) MessageId
+
+	// Progress sends a message that can be edited to report the progress of a long running operation
+	Progress(initial string) ProgressReporter
+
+	// Fail logs the error, sends the message to the user and leaves the current state.
+	// The last state on the stack is not left, as it would be re-created and likely fail again.
 	Fail(message string, formatErrorMsg string, args ...interface{})
+	// FailStay logs the error and sends the message to the user without leaving the current state
+	FailStay(message string, formatErrorMsg string, args ...interface{})
 
 	RootState() State[T]
 	PushState(state State[T])
 	PopState()
+	// Reenter leaves and re-activates the current state, so its messages and keyboards are created
+	// only once, as the BeforeLeave handlers are expected to clean them up.
+	Reenter()
+	// ReplaceState leaves the current state and activates the passed state instead.
+	// Passing the current state reloads it.
 	ReplaceState(state State[T])
 	ResetToState(state State[T])
+	// DropStates removes the n topmost states and returns to the state below them.
+	// Like all transitions, it is aborted if the current state vetoes it, see LeaveGuard.
 	DropStates(n int)
 	SendError(err error)
 	CurrentState() State[T]
+	// CurrentStateName returns the name of the current state, empty if it is anonymous. See StateBuilder.Named.
+	CurrentStateName() string
+	// StackDepth returns the number of states on the stack
+	StackDepth() int
 
 	RemoveKeyboardForMessage(messageId MessageId)
+	// RemoveKeyboards removes the inline keyboards of the messages, or of all recently sent messages
+	// if no IDs are passed. The recently sent messages are persisted and their keyboards are removed
+	// when the bot restarts, as their buttons are not handled anymore.
+	RemoveKeyboards(messageIds ...MessageId)
 
 	// returns the current user ID
 	UserId() UserId
 
+	// returns the session's chat ID
+	ChatId() ChatId
+
+	// RawAPI returns the telegram API as an escape hatch for features not supported by the session.
+	// Messages sent through it bypass the session, so e.g. they need to be addressed using ChatId().
+	RawAPI() TGApi
+
 	AcceptUsers(duration time.Duration)
 
+	// DeleteUser deletes a user and their sessions
+	DeleteUser(userId UserId) error
+
 	BotName() (string, error)
 
+	// BotStats returns runtime statistics of the bot
+	BotStats() BotStats
+
 	Context() context.Context
 
+	// OperationContext returns a context for background work of the current state. It's derived from
+	// the bot's context and cancelled when the current state is left, i.e. popped, replaced, reset or
+	// when another state is pushed on top, so goroutines stop when the user navigates away.
+	// Call the returned cancel func when the work is done.
+	OperationContext() (context.Context, context.CancelFunc)
+
+	// SendChatAction shows the action, e.g. tgbotapi.ChatTyping, to the user for about 5 seconds
+	// or until the bot sends a message
+	SendChatAction(action string)
+
+	// Do runs fn on the bot's update loop, so goroutines can safely access the session.
+	// It returns once fn is enqueued without waiting for it. Called from a handler,
+	// fn runs after the handler returned.
+	Do(fn func(bs Session[T]))
+
 	State() T
 
 	LastUserAction() time.Time
+
+	// LastUpdateID returns the ID of the last update the session handled, e.g. for deduplication
+	LastUpdateID() int
+
+	// returns true if the user blocked the bot or the bot was removed from the chat
+	IsBlocked() bool
+
+	// Settings returns the session's settings, which can be modified and are persisted with the session
+	Settings() *SessionSettings
+
+	// TimeZone returns the user's time zone from the session's settings, defaults to local time.
+	// It is passed to templates as value "timeZone".
+	TimeZone() *time.Location
+	// SetTimeZone stores the time zone's name in the session's settings, so it's persisted.
+	// The zone should be loaded by time.LoadLocation, as it's loaded by name after a restart.
+	SetTimeZone(loc *time.Location)
+
+	// Set stores transient data in the session, e.g. inputs of a multi step flow.
+	// Values are not persisted and are cleared by ResetToState.
+	Set(key string, value any)
+	Get(key string) (any, bool)
 }
 
+// make sure session is the canonical implementation of Session
+var _ Session[any] = (*session[any])(nil)
+
 type session[T any] struct {
 	botApi TGApi
 
@@ -78,12 +282,40 @@ type session[T any] struct {
 	bot *Bot[T]
 
 	lastUserAction time.Time
+	lastUpdateID   int
 
 	stateStack []State[T]
 
 	botCtx context.Context
 
 	sessionCommandHandlers map[string]CommandHandler[T]
+
+	// transient key/values of the session, not persisted
+	mValues sync.Mutex
+	values  map[string]any
+
+	settings SessionSettings
+
+	// time zone loaded for the name in the settings, nil if loading failed
+	timeZone     *time.Location
+	timeZoneName string
+
+	// set if the user blocked the bot or the bot was removed from the chat
+	blocked bool
+
+	// cancel funcs of the contexts returned by OperationContext for the current state
+	operationCancels []context.CancelFunc
+
+	// recently sent messages with inline keyboard, so their keyboards can be removed, e.g. after a restart
+	inlineMessages []MessageId
+	// owners of the inline messages, not persisted
+	inlineOwners map[MessageId]inlineOwner
+
+	// user of the update handled last, who triggered the messages sent in response
+	currentUserId UserId
+
+	// keyboard of the current state, attached to every message that does not specify a keyboard
+	stateKeyboard Keyboard
 }
 
 func NewSession[T any](userId UserId, chatId ChatId, appState T, bot *Bot[T], botCtx context.Context, botApi TGApi) *session[T] {
@@ -107,6 +339,30 @@ func (bs *session[T]) Context() context.Context {
 	return bs.botCtx
 }
 
+func (bs *session[T]) SendChatAction(action string) {
+	if _, err := bs.botApi.Request(tgbotapi.NewChatAction(int64(bs.chatId), action)); err != nil {
+		log.Printf("error sending chat action: %v", err)
+	}
+}
+
+func (bs *session[T]) OperationContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(bs.botCtx)
+	bs.operationCancels = append(bs.operationCancels, cancel)
+	return ctx, cancel
+}
+
+// cancelOperations cancels the contexts of the current state's operations
+func (bs *session[T]) cancelOperations() {
+	for _, cancel := range bs.operationCancels {
+		cancel()
+	}
+	bs.operationCancels = nil
+}
+
+func (bs *session[T]) Do(fn func(bs Session[T])) {
+	bs.bot.enqueue(func() { fn(bs) })
+}
+
 func (bs *session[T]) getOrPushCurrentState() State[T] {
 	if len(bs.stateStack) == 0 {
 		bs.stateStack = []State[T]{bs.bot.rootState()}
@@ -123,14 +379,86 @@ func (bs *session[T]) AcceptUsers(duration time.Duration) {
 	bs.bot.AcceptUsers(duration)
 }
 
+func (bs *session[T]) DeleteUser(userId UserId) error {
+	return bs.bot.DeleteUser(userId)
+}
+
 func (bs *session[T]) LastUserAction() time.Time {
 	return bs.lastUserAction
 }
 
+func (bs *session[T]) LastUpdateID() int {
+	return bs.lastUpdateID
+}
+
+func (bs *session[T]) IsBlocked() bool {
+	return bs.blocked
+}
+
+func (bs *session[T]) Settings() *SessionSettings {
+	return &bs.settings
+}
+
+func (bs *session[T]) TimeZone() *time.Location {
+	// the settings might have been modified directly
+	if bs.settings.TimeZone != bs.timeZoneName {
+		if err := bs.loadTimeZone(); err != nil {
+			log.Printf("%v, using local time", err)
+		}
+	}
+	if bs.timeZone == nil {
+		return time.Local
+	}
+	return bs.timeZone
+}
+
+func (bs *session[T]) SetTimeZone(loc *time.Location) {
+	var name string
+	if loc != nil {
+		name = loc.String()
+	}
+	bs.settings.TimeZone = name
+	bs.timeZone, bs.timeZoneName = loc, name
+}
+
+// loadTimeZone loads the time zone named in the settings
+func (bs *session[T]) loadTimeZone() error {
+	bs.timeZone, bs.timeZoneName = nil, bs.settings.TimeZone
+	if bs.timeZoneName == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(bs.timeZoneName)
+	if err != nil {
+		return fmt.Errorf("error loading time zone %q of chat %d: %w", bs.timeZoneName, bs.chatId, err)
+	}
+	bs.timeZone = loc
+	return nil
+}
+
+func (bs *session[T]) Set(key string, value any) {
+	bs.mValues.Lock()
+	defer bs.mValues.Unlock()
+	if bs.values == nil {
+		bs.values = make(map[string]any)
+	}
+	bs.values[key] = value
+}
+
+func (bs *session[T]) Get(key string) (any, bool) {
+	bs.mValues.Lock()
+	defer bs.mValues.Unlock()
+	value, ok := bs.values[key]
+	return value, ok
+}
+
 func (bs *session[T]) Handle(update tgbotapi.Update) bool {
 	curState := bs.getOrPushCurrentState()
 
 	bs.lastUserAction = time.Now()
+	bs.lastUpdateID = update.UpdateID
+	if from := update.SentFrom(); from != nil {
+		bs.currentUserId = UserId(from.ID)
+	}
 
 	switch {
 	case update.Message != nil:
@@ -138,7 +466,7 @@ func (bs *session[T]) Handle(update tgbotapi.Update) bool {
 		// if the message is a command, try to handle that instead.
 		// First the current stae, then the context
 		if cmd := update.Message.CommandWithAt(); cmd != "" {
-			args := strings.Split(update.Message.CommandArguments(), " ")
+			args := SplitCommandArgs(update.Message.CommandArguments())
 			if curState.HandleCommand(bs, cmd, args...) {
 				return true
 			}
@@ -146,9 +474,19 @@ func (bs *session[T]) Handle(update tgbotapi.Update) bool {
 		}
 
 		return curState.HandleMessage(bs, &tgMessage{m: update.Message})
+	case update.EditedMessage != nil:
+		if handler, ok := curState.(EditedMessageHandler[T]); ok {
+			return handler.HandleEditedMessage(bs, &tgMessage{m: update.EditedMessage})
+		}
+		return false
 	case update.CallbackQuery != nil:
+		query := &tgCbQuery{m: update.CallbackQuery}
+		if owner, ok := bs.inlineOwners[query.MessageID()]; ok && owner.exclusive && owner.userId != query.From() {
+			bs.AnswerCallbackQuery(query.ID(), "not for you", true)
+			return true
+		}
 
-		if curState.HandleCallbackQuery(bs, &tgCbQuery{m: update.CallbackQuery}) {
+		if curState.HandleCallbackQuery(bs, query) {
 			return true
 		} else {
 			return bs.removeExpiredCallback(update.CallbackQuery)
@@ -161,6 +499,11 @@ func (bs *session[T]) Handle(update tgbotapi.Update) bool {
 }
 
 func (bs *session[T]) removeExpiredCallback(query *tgbotapi.CallbackQuery) bool {
+	if handler := bs.bot.config.OnExpiredCallback; handler != nil {
+		handler(bs, &tgCbQuery{m: query})
+		return true
+	}
+
 	alert := tgbotapi.NewCallbackWithAlert(query.InlineMessageID, "message expired, buttons disabled")
 	alert.CallbackQueryID = query.ID
 
@@ -175,6 +518,8 @@ func (bs *session[T]) removeExpiredCallback(query *tgbotapi.CallbackQuery) bool
 }
 
 func (bs *session[T]) RemoveKeyboardForMessage(messageId MessageId) {
+	bs.untrackInlineMessage(messageId)
+
 	// construct an update reply-markup message manually, because we need to set
 	// the ReplyMarkup to nil, which is not supported by the library
 	bs.botApi.Request(tgbotapi.EditMessageReplyMarkupConfig{
@@ -186,19 +531,34 @@ func (bs *session[T]) RemoveKeyboardForMessage(messageId MessageId) {
 	})
 }
 
-func (bs *session[T]) handleCommand(command string, args []string) bool {
-	switch command {
-	case CommandCancel.Command:
-		bs.PopState()
-		return true
+func (bs *session[T]) RemoveKeyboards(messageIds ...MessageId) {
+	if len(messageIds) == 0 {
+		messageIds = slices.Clone(bs.inlineMessages)
 	}
+	for _, messageId := range messageIds {
+		bs.RemoveKeyboardForMessage(messageId)
+	}
+}
 
+func (bs *session[T]) handleCommand(command string, args []string) bool {
 	for _, handler := range bs.sessionCommandHandlers {
 		if handler.Handle(bs, command, args...) {
 			return true
 		}
 	}
 
+	for _, handler := range bs.bot.config.CommandHandlers {
+		if handler.Command == command && handler.Handler.Handle(bs, command, args...) {
+			return true
+		}
+	}
+
+	switch command {
+	case CommandCancel.Command:
+		bs.PopState()
+		return true
+	}
+
 	return false
 }
 
@@ -209,9 +569,18 @@ func (bs *session[T]) SetCommandHandler(name string, handler CommandHandler[T])
 
 func (bs *session[T]) PushState(state State[T]) {
 	if len(bs.stateStack) > 0 {
+		if !bs.canLeave() {
+			return
+		}
 		bs.CurrentState().BeforeLeave(bs)
 	}
+	bs.cancelOperations()
+	bs.stateKeyboard = nil
 	bs.stateStack = append(bs.stateStack, state)
+	if maxDepth := bs.bot.config.MaxStackDepth; maxDepth > 0 && len(bs.stateStack) > maxDepth {
+		// drop the oldest states, they have been left already
+		bs.stateStack = bs.stateStack[len(bs.stateStack)-maxDepth:]
+	}
 	state.Activate(bs)
 }
 
@@ -220,7 +589,12 @@ func (bs *session[T]) PopState() {
 		return
 	}
 
+	if !bs.canLeave() {
+		return
+	}
 	bs.CurrentState().BeforeLeave(bs)
+	bs.cancelOperations()
+	bs.stateKeyboard = nil
 
 	bs.stateStack = bs.stateStack[:len(bs.stateStack)-1]
 
@@ -230,14 +604,25 @@ func (bs *session[T]) PopState() {
 }
 
 func (bs *session[T]) DropStates(n int) {
+	if len(bs.stateStack) > 0 && !bs.canLeave() {
+		return
+	}
 	if len(bs.stateStack) > n {
 		bs.stateStack = bs.stateStack[:len(bs.stateStack)-n]
 	} else {
 		bs.stateStack = nil
 	}
+	bs.cancelOperations()
+	bs.stateKeyboard = nil
 	bs.getOrPushCurrentState().Return(bs)
 }
 
+// canLeave returns whether the current state may be left, see LeaveGuard
+func (bs *session[T]) canLeave() bool {
+	guard, ok := bs.CurrentState().(LeaveGuard[T])
+	return !ok || guard.CanLeave(bs)
+}
+
 func (bs *session[T]) CurrentState() State[T] {
 	if len(bs.stateStack) == 0 {
 		return nil
@@ -245,17 +630,49 @@ func (bs *session[T]) CurrentState() State[T] {
 	return bs.stateStack[len(bs.stateStack)-1]
 }
 
+func (bs *session[T]) CurrentStateName() string {
+	if named, ok := bs.CurrentState().(NamedState); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+func (bs *session[T]) Reenter() {
+	if curState := bs.CurrentState(); curState != nil {
+		bs.ReplaceState(curState)
+	}
+}
+
+func (bs *session[T]) StackDepth() int {
+	return len(bs.stateStack)
+}
+
 func (bs *session[T]) ReplaceState(state State[T]) {
 	if len(bs.stateStack) == 0 {
 		return
 	}
+	if !bs.canLeave() {
+		return
+	}
 
+	// leave the replaced state first, which also resets it if it is activated again (e.g. on /reload)
+	bs.CurrentState().BeforeLeave(bs)
+	bs.cancelOperations()
+	bs.stateKeyboard = nil
 	bs.stateStack[len(bs.stateStack)-1] = state
 	state.Activate(bs)
 }
 
 func (bs *session[T]) ResetToState(state State[T]) {
+	if len(bs.stateStack) > 0 && !bs.canLeave() {
+		return
+	}
 	bs.stateStack = nil
+
+	bs.mValues.Lock()
+	bs.values = nil
+	bs.mValues.Unlock()
+
 	bs.PushState(state)
 }
 
@@ -267,27 +684,90 @@ func (bs *session[T]) ChatId() ChatId {
 	return bs.chatId
 }
 
+func (bs *session[T]) RawAPI() TGApi {
+	return bs.botApi
+}
+
 func (bs *session[T]) SendTemplateMessage(template string, values KeyValues, opts ...SendMessageOption) Message {
 	template = strings.TrimSpace(template)
+	// values passed by the caller take precedence
+	if !slices.ContainsFunc(values, func(value KeyValue) bool { return value.Key() == "timeZone" }) {
+		values = append(KeyValues{KV("timeZone", bs.TimeZone())}, values...)
+	}
 	value, err := RunTemplate(template, values...)
 	if err != nil {
-		bs.SendError(err)
+		log.Printf("error rendering template in state %q: %v", bs.CurrentStateName(), err)
+		bs.SendMessage("Sorry, this message could not be displayed 😵", SendMessageKeepKeyboard())
+		return &message{}
 	}
 	return bs.SendMessage(value, opts...)
 }
 
 func (bs *session[T]) SendMessage(text string, opts ...SendMessageOption) Message {
+	msg, err := bs.TrySendMessage(text, opts...)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	return msg
+}
+
+// TrySendMessage sends the text, which is split into multiple messages if it exceeds
+// telegram's length limit. The keyboard is attached to the last one, which is returned.
+func (bs *session[T]) TrySendMessage(text string, opts ...SendMessageOption) (Message, error) {
+	parts := splitMessage(bs.transformText(text), MaxMessageLength)
+	for _, part := range parts[:len(parts)-1] {
+		if _, err := bs.sendText(part, false, opts...); err != nil {
+			return &message{}, err
+		}
+	}
+	return bs.sendText(parts[len(parts)-1], true, opts...)
+}
+
+// transformText applies the config's MessageTransformer
+func (bs *session[T]) transformText(text string) string {
+	if transformer := bs.bot.config.MessageTransformer; transformer != nil {
+		return transformer(text)
+	}
+	return text
+}
+
+func (bs *session[T]) sendText(text string, withMarkup bool, opts ...SendMessageOption) (Message, error) {
 	msg := tgbotapi.NewMessage(int64(bs.ChatId()), text)
-	msg.ParseMode = "html"
+	msg.ParseMode = newSendMessageOptions(opts...).parseMode
 
-	options := &sendMessageOptions{}
-	for _, opt := range opts {
-		opt(options)
+	if err := bs.applySendOptions(&msg.BaseChat, opts...); err != nil {
+		return &message{}, fmt.Errorf("error sending message: %w", err)
 	}
+	if !withMarkup {
+		msg.ReplyMarkup = nil
+	}
+
+	sentMsg, err := bs.botApi.Send(msg)
+	if err != nil {
+		return &message{messageId: sentMsg.MessageID}, fmt.Errorf("error sending message %#v: %w", msg, err)
+	}
+	bs.bot.messagesSent.Add(1)
+	bs.trackInlineMessage(MessageId(sentMsg.MessageID), msg.ReplyMarkup, newSendMessageOptions(opts...).ownerOnly)
+	bs.notifyOutgoing(msg.Text, OutgoingMessage)
+	return &message{messageId: sentMsg.MessageID, editor: bs}, nil
+}
 
-	if options.keyboard != nil {
+// applySendOptions sets the reply markup and notification of a message to be sent
+func (bs *session[T]) applySendOptions(chat *tgbotapi.BaseChat, opts ...SendMessageOption) error {
+	options := newSendMessageOptions(opts...)
+
+	// re-attach the state's keyboard unless the message brings its own
+	if options.keyboard == nil && len(options.inlineKeyboard) == 0 && !options.keepKeyboard && !options.forceReply {
+		options.keyboard = bs.stateKeyboard
+	}
+
+	if options.forceReply {
+		chat.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true}
+	} else if options.keyboard != nil {
 		keyboard := tgbotapi.ReplyKeyboardMarkup{
-			ResizeKeyboard: true,
+			ResizeKeyboard:  true,
+			OneTimeKeyboard: options.oneTimeKeyboard,
+			Selective:       options.selectiveKeyboard,
 		}
 		for _, row := range options.keyboard.Buttons() {
 			// rows might be nil
@@ -296,42 +776,333 @@ func (bs *session[T]) SendMessage(text string, opts ...SendMessageOption) Messag
 			}
 			var rowKeys []tgbotapi.KeyboardButton
 			for _, cmd := range row {
-				rowKeys = append(rowKeys, tgbotapi.NewKeyboardButton(string(cmd)))
+				button := tgbotapi.NewKeyboardButton(string(cmd))
+				if requester, ok := options.keyboard.(buttonRequester); ok {
+					button.RequestContact = requester.requestsContact(cmd)
+					button.RequestLocation = requester.requestsLocation(cmd)
+				}
+				rowKeys = append(rowKeys, button)
 			}
 			keyboard.Keyboard = append(keyboard.Keyboard, rowKeys)
 		}
 
-		msg.ReplyMarkup = keyboard
+		chat.ReplyMarkup = keyboard
+		if requester, ok := options.keyboard.(buttonRequester); ok {
+			chat.ReplyMarkup = replyMarkup(keyboard, requester)
+		}
 
 	} else if len(options.inlineKeyboard) > 0 {
-
-		markup := tgbotapi.NewInlineKeyboardMarkup()
-		for _, row := range options.inlineKeyboard {
-			keyboardRow := tgbotapi.NewInlineKeyboardRow()
-			for _, button := range row {
-				keyboardRow = append(keyboardRow, tgbotapi.NewInlineKeyboardButtonData(button.Label, button.Data))
-			}
-			markup.InlineKeyboard = append(markup.InlineKeyboard, keyboardRow)
+		if err := options.inlineKeyboard.Validate(); err != nil {
+			return err
 		}
-		msg.ReplyMarkup = markup
+		chat.ReplyMarkup = inlineMarkup(options.inlineKeyboard)
 	} else {
 		if !options.keepKeyboard {
-			msg.ReplyMarkup = tgbotapi.ReplyKeyboardRemove{RemoveKeyboard: true}
+			chat.ReplyMarkup = tgbotapi.ReplyKeyboardRemove{RemoveKeyboard: true}
 		}
 	}
-	msg.DisableNotification = !options.notification
+	notification := bs.bot.config.DefaultNotification
+	if options.notification != nil {
+		notification = *options.notification
+	}
+	chat.DisableNotification = !notification || bs.settings.PauseAllNotifications
+	return nil
+}
 
-	sentMsg, err := bs.botApi.Send(msg)
+// maximum number of messages with inline keyboard tracked per session
+const maxTrackedInlineMessages = 50
+
+// inlineOwner is the user that triggered sending an inline message
+type inlineOwner struct {
+	userId UserId
+	// only the owner may press the buttons, see SendMessageOwnerOnly
+	exclusive bool
+}
+
+// trackInlineMessage remembers the message and its owner if it was sent with an inline keyboard
+func (bs *session[T]) trackInlineMessage(messageId MessageId, markup any, ownerOnly bool) {
+	switch markup.(type) {
+	case tgbotapi.InlineKeyboardMarkup, webAppInlineMarkup:
+	default:
+		return
+	}
+	if messageId == 0 {
+		return
+	}
+	if bs.inlineOwners == nil {
+		bs.inlineOwners = make(map[MessageId]inlineOwner)
+	}
+	bs.inlineOwners[messageId] = inlineOwner{userId: bs.currentUser(), exclusive: ownerOnly}
+	if slices.Contains(bs.inlineMessages, messageId) {
+		return
+	}
+	bs.inlineMessages = append(bs.inlineMessages, messageId)
+	if len(bs.inlineMessages) > maxTrackedInlineMessages {
+		dropped := len(bs.inlineMessages) - maxTrackedInlineMessages
+		for _, id := range bs.inlineMessages[:dropped] {
+			delete(bs.inlineOwners, id)
+		}
+		bs.inlineMessages = slices.Delete(bs.inlineMessages, 0, dropped)
+	}
+}
+
+func (bs *session[T]) untrackInlineMessage(messageId MessageId) {
+	bs.inlineMessages = slices.DeleteFunc(bs.inlineMessages, func(id MessageId) bool { return id == messageId })
+	delete(bs.inlineOwners, messageId)
+}
+
+func (bs *session[T]) InlineMessageOwner(messageId MessageId) (UserId, bool) {
+	owner, ok := bs.inlineOwners[messageId]
+	return owner.userId, ok
+}
+
+// currentUser returns the user of the update handled last, defaulting to the session's user
+func (bs *session[T]) currentUser() UserId {
+	if bs.currentUserId != 0 {
+		return bs.currentUserId
+	}
+	return bs.userId
+}
+
+func (bs *session[T]) SetStateKeyboard(keyboard Keyboard) {
+	bs.stateKeyboard = keyboard
+}
+
+func (bs *session[T]) Progress(initial string) ProgressReporter {
+	return &progressReporter{
+		msg: bs.SendMessage("⏳ "+initial, SendMessageKeepKeyboard()),
+	}
+}
+
+func (bs *session[T]) SendVenue(lat, lon float64, title, address string, opts ...SendMessageOption) Message {
+	venue := tgbotapi.NewVenue(int64(bs.chatId), title, address, lat, lon)
+	return bs.sendChattable(&venue, &venue.BaseChat, opts...)
+}
+
+func (bs *session[T]) SendContact(phone, firstName string, opts ...SendMessageOption) Message {
+	contact := tgbotapi.NewContact(int64(bs.chatId), phone, firstName)
+	return bs.sendChattable(&contact, &contact.BaseChat, opts...)
+}
+
+func (bs *session[T]) PinMessage(messageId MessageId, silent bool) {
+	pin := tgbotapi.PinChatMessageConfig{
+		ChatID:              int64(bs.chatId),
+		MessageID:           int(messageId),
+		DisableNotification: silent,
+	}
+	if _, err := bs.botApi.Request(pin); err != nil {
+		logRightsError("pinning message", err)
+	}
+}
+
+func (bs *session[T]) UnpinMessage(messageId MessageId) {
+	unpin := tgbotapi.UnpinChatMessageConfig{
+		ChatID:    int64(bs.chatId),
+		MessageID: int(messageId),
+	}
+	if _, err := bs.botApi.Request(unpin); err != nil {
+		logRightsError("unpinning message", err)
+	}
+}
+
+func (bs *session[T]) RestrictUser(userId UserId, duration time.Duration) error {
+	restrict := tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig: bs.memberConfig(userId),
+		UntilDate:        untilDate(duration),
+		Permissions:      &tgbotapi.ChatPermissions{},
+	}
+	return rightsError("restricting user", bs.request(restrict))
+}
+
+func (bs *session[T]) BanUser(userId UserId, duration time.Duration) error {
+	ban := tgbotapi.BanChatMemberConfig{
+		ChatMemberConfig: bs.memberConfig(userId),
+		UntilDate:        untilDate(duration),
+	}
+	return rightsError("banning user", bs.request(ban))
+}
+
+func (bs *session[T]) UnbanUser(userId UserId) error {
+	unban := tgbotapi.UnbanChatMemberConfig{
+		ChatMemberConfig: bs.memberConfig(userId),
+		OnlyIfBanned:     true,
+	}
+	return rightsError("unbanning user", bs.request(unban))
+}
+
+func (bs *session[T]) memberConfig(userId UserId) tgbotapi.ChatMemberConfig {
+	return tgbotapi.ChatMemberConfig{
+		ChatID: int64(bs.chatId),
+		UserID: int64(userId),
+	}
+}
+
+func (bs *session[T]) request(c tgbotapi.Chattable) error {
+	_, err := bs.botApi.Request(c)
+	return err
+}
+
+// untilDate converts the duration to the unix time telegram expects, 0 meaning forever
+func untilDate(duration time.Duration) int64 {
+	if duration <= 0 {
+		return 0
+	}
+	return time.Now().Add(duration).Unix()
+}
+
+// ErrNotEnoughRights is returned if the bot lacks the admin rights for an action in a group
+var ErrNotEnoughRights = errors.New("not enough rights")
+
+// rightsError wraps the error of an action, detecting missing admin rights of the bot
+func rightsError(action string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "not enough rights") {
+		return fmt.Errorf("error %s: %w: %v", action, ErrNotEnoughRights, err)
+	}
+	return fmt.Errorf("error %s: %w", action, err)
+}
+
+// logRightsError logs the error of an action, pointing out missing admin rights of the bot
+func logRightsError(action string, err error) {
+	log.Printf("%v", rightsError(action, err))
+}
+
+// DiceEmojis are the emojis supported by SendDice
+var DiceEmojis = []string{"🎲", "🎯", "🏀", "⚽", "🎳", "🎰"}
+
+func (bs *session[T]) SendDice(emoji string) (Message, int) {
+	if emoji == "" {
+		emoji = DiceEmojis[0]
+	}
+	if !slices.Contains(DiceEmojis, emoji) {
+		log.Printf("Error sending dice: unsupported emoji %q", emoji)
+		return &message{}, 0
+	}
+
+	sentMsg, err := bs.botApi.Send(tgbotapi.NewDiceWithEmoji(int64(bs.chatId), emoji))
+	if err != nil {
+		log.Printf("Error sending dice: %v", err)
+		return &message{messageId: sentMsg.MessageID}, 0
+	}
+
+	var value int
+	if sentMsg.Dice != nil {
+		value = sentMsg.Dice.Value
+	}
+	return &message{messageId: sentMsg.MessageID, editor: bs}, value
+}
+
+// PollConfig configures a poll sent by SendPoll
+type PollConfig struct {
+	Anonymous       bool
+	MultipleAnswers bool
+
+	// Quiz makes the poll a quiz with CorrectOption being the index of the correct answer
+	Quiz          bool
+	CorrectOption int
+}
+
+func (bs *session[T]) SendPoll(question string, options []string, cfg PollConfig) Message {
+	poll := tgbotapi.NewPoll(int64(bs.chatId), question, options...)
+	poll.IsAnonymous = cfg.Anonymous
+	poll.AllowsMultipleAnswers = cfg.MultipleAnswers
+	if cfg.Quiz {
+		poll.Type = "quiz"
+		poll.CorrectOptionID = int64(cfg.CorrectOption)
+	}
+
+	sentMsg, err := bs.botApi.Send(poll)
+	if err != nil {
+		log.Printf("Error sending poll %#v: %v", poll, err)
+		return &message{messageId: sentMsg.MessageID}
+	}
+
+	var pollId string
+	if sentMsg.Poll != nil {
+		pollId = sentMsg.Poll.ID
+		bs.bot.registerPoll(pollId, bs.chatId)
+	}
+	return &message{messageId: sentMsg.MessageID, pollId: pollId, editor: bs}
+}
+
+func (bs *session[T]) ForwardMessage(toChat ChatId, fromChat ChatId, messageId MessageId) Message {
+	forward := tgbotapi.NewForward(int64(toChat), int64(fromChat), int(messageId))
+	sentMsg, err := bs.botApi.Send(forward)
+	if err != nil {
+		log.Printf("Error forwarding message %#v: %v", forward, err)
+		return &message{messageId: sentMsg.MessageID}
+	}
+	// the session can only edit messages in its own chat
+	if toChat != bs.chatId {
+		return &message{messageId: sentMsg.MessageID}
+	}
+	return &message{messageId: sentMsg.MessageID, editor: bs}
+}
+
+func (bs *session[T]) CopyMessage(toChat ChatId, fromChat ChatId, messageId MessageId) MessageId {
+	copyMsg := tgbotapi.NewCopyMessage(int64(toChat), int64(fromChat), int(messageId))
+	resp, err := bs.botApi.Request(copyMsg)
 	if err != nil {
-		log.Printf("Error sending message %#v: %v", msg, err)
+		log.Printf("Error copying message %#v: %v", copyMsg, err)
+		return 0
+	}
+	if resp == nil {
+		return 0
+	}
+
+	var copied tgbotapi.MessageID
+	if err := json.Unmarshal(resp.Result, &copied); err != nil {
+		log.Printf("Error decoding copied message id: %v", err)
+		return 0
 	}
-	return &message{messageId: sentMsg.MessageID}
+	return MessageId(copied.MessageID)
 }
 
 func (bs *session[T]) SendError(err error) {
-	_, sendErr := bs.botApi.Send(tgbotapi.NewMessage(int64(bs.ChatId()), fmt.Sprintf("error: %v", err)))
+	text := fmt.Sprintf("error: %v", err)
+	_, sendErr := bs.botApi.Send(tgbotapi.NewMessage(int64(bs.ChatId()), text))
 	if sendErr != nil {
 		log.Printf("Error sending error: %v", sendErr)
+		return
+	}
+	bs.notifyOutgoing(text, OutgoingError)
+}
+
+// kinds of outgoing texts passed to Config.OnOutgoing
+const (
+	OutgoingMessage = "message"
+	OutgoingUpdate  = "update"
+	OutgoingError   = "error"
+)
+
+// number of texts waiting for the OnOutgoing hook before new ones are dropped
+const outgoingBuffer = 100
+
+type outgoingText struct {
+	chatId ChatId
+	text   string
+	kind   string
+}
+
+// notifyOutgoing passes the text to the config's OnOutgoing hook without waiting for it.
+// If the hook can't keep up, the text is dropped.
+func (bs *session[T]) notifyOutgoing(text string, kind string) {
+	if bs.bot.outgoing == nil {
+		return
+	}
+	select {
+	case bs.bot.outgoing <- outgoingText{chatId: bs.chatId, text: text, kind: kind}:
+	default:
+		log.Printf("OnOutgoing hook is too slow, dropping %s for chat %d", kind, bs.chatId)
+	}
+}
+
+// runOutgoing calls the OnOutgoing hook for the queued texts, one at a time
+func (b *Bot[T]) runOutgoing() {
+	for outgoing := range b.outgoing {
+		b.config.OnOutgoing(outgoing.chatId, outgoing.text, outgoing.kind)
 	}
 }
 
@@ -340,11 +1111,48 @@ type (
 		keyboard       Keyboard
 		keepKeyboard   bool
 		inlineKeyboard InlineKeyboard
-		notification   bool
+		forceReply     bool
+
+		// reply keyboard flags
+		oneTimeKeyboard   bool
+		selectiveKeyboard bool
+
+		// nil to use the config's default
+		notification *bool
+
+		// only the user that triggered the message may press its inline buttons
+		ownerOnly bool
+
+		parseMode string
 	}
 	SendMessageOption func(options *sendMessageOptions)
 )
 
+func newSendMessageOptions(opts ...SendMessageOption) *sendMessageOptions {
+	options := &sendMessageOptions{
+		parseMode: ParseModeHTML,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// parse modes for SendMessageParseMode
+const (
+	ParseModeHTML       = tgbotapi.ModeHTML
+	ParseModeMarkdownV2 = tgbotapi.ModeMarkdownV2
+	ParseModeNone       = ""
+)
+
+// SendMessageParseMode sets how telegram parses the text's formatting, defaults to ParseModeHTML.
+// Note that long messages are split assuming html, so other modes may break their formatting.
+func SendMessageParseMode(mode string) SendMessageOption {
+	return func(opts *sendMessageOptions) {
+		opts.parseMode = mode
+	}
+}
+
 func SendMessageKeepKeyboard() SendMessageOption {
 	return func(opts *sendMessageOptions) {
 		opts.keepKeyboard = true
@@ -354,44 +1162,148 @@ func SendMessageKeepKeyboard() SendMessageOption {
 func SendMessageInlineKeyboard(keyboard InlineKeyboard) SendMessageOption {
 	return func(opts *sendMessageOptions) {
 		opts.inlineKeyboard = keyboard
+		opts.forceReply = false
+	}
+}
+
+// SendMessageForceReply makes the client show a reply interface for the message, so the user
+// can directly type an answer. It is mutually exclusive with the keyboard options, the last one wins.
+func SendMessageForceReply() SendMessageOption {
+	return func(opts *sendMessageOptions) {
+		opts.forceReply = true
+		opts.keyboard = nil
+		opts.inlineKeyboard = nil
+	}
+}
+
+// SendMessageOwnerOnly restricts the message's inline buttons to the user whose update triggered
+// sending the message. Other users pressing a button, e.g. in a group chat, get an alert.
+// Restrictions are lost when the bot restarts, but the keyboards are removed then anyway.
+func SendMessageOwnerOnly() SendMessageOption {
+	return func(opts *sendMessageOptions) {
+		opts.ownerOnly = true
 	}
 }
 
 func SendMessageWithNotification() SendMessageOption {
 	return func(opts *sendMessageOptions) {
-		opts.notification = true
+		notification := true
+		opts.notification = &notification
+	}
+}
+
+func SendMessageSilent() SendMessageOption {
+	return func(opts *sendMessageOptions) {
+		notification := false
+		opts.notification = &notification
+	}
+}
+
+// SendMessageOneTimeKeyboard hides the message's reply keyboard after a button was pressed
+func SendMessageOneTimeKeyboard() SendMessageOption {
+	return func(opts *sendMessageOptions) {
+		opts.oneTimeKeyboard = true
 	}
 }
+
+// SendMessageSelectiveKeyboard shows the message's reply keyboard only to mentioned users
+// and the sender of the message replied to.
+func SendMessageSelectiveKeyboard() SendMessageOption {
+	return func(opts *sendMessageOptions) {
+		opts.selectiveKeyboard = true
+	}
+}
+
 func SendMessageWithKeyboard(keyboard Keyboard) SendMessageOption {
 	return func(opts *sendMessageOptions) {
 		opts.keyboard = keyboard
+		opts.forceReply = false
 	}
 }
 
 func (bs *session[T]) UpdateMessageForCallback(queryId string, messageId MessageId, text string, opts ...SendMessageOption) {
+	bs.updateMessage(messageId, text, opts...)
+	bs.answerCallback(queryId)
+}
+
+func (bs *session[T]) answerCallback(queryId string) {
+	bs.botApi.Request(tgbotapi.NewCallback(queryId, ""))
+}
+
+func (bs *session[T]) AnswerCallbackQuery(queryId string, text string, alert bool) {
+	callback := tgbotapi.NewCallback(queryId, text)
+	callback.ShowAlert = alert
+	if _, err := bs.botApi.Request(callback); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+}
+
+func (bs *session[T]) updateMessage(messageId MessageId, text string, opts ...SendMessageOption) {
+	// edits cannot be split, so overlong texts are cut
+	parts := splitMessage(bs.transformText(text), MaxMessageLength)
+	if len(parts) > 1 {
+		log.Printf("message %d exceeds %d characters, cutting it", messageId, MaxMessageLength)
+	}
+	text = parts[0]
+
 	edit := tgbotapi.EditMessageTextConfig{
 		BaseEdit: tgbotapi.BaseEdit{
 			ChatID:    int64(bs.chatId),
 			MessageID: int(messageId),
 		},
-		Text:      text,
-		ParseMode: "html",
+		Text: text,
 	}
 
-	options := &sendMessageOptions{}
-	for _, opt := range opts {
-		opt(options)
-	}
+	options := newSendMessageOptions(opts...)
+	edit.ParseMode = options.parseMode
 
-	if len(options.inlineKeyboard) > 0 {
-		edit.BaseEdit.ReplyMarkup = convertToMarkup(options.inlineKeyboard)
+	markup, webApp, err := editMarkup(options.inlineKeyboard)
+	if err != nil {
+		log.Printf("error updating message: %v", err)
+		return
+	}
+	if webApp {
+		err = bs.editWebAppKeyboard(messageId, options.inlineKeyboard, edit.Text, edit.ParseMode)
+	} else {
+		edit.BaseEdit.ReplyMarkup = markup
+		_, err = bs.botApi.Request(edit)
 	}
+	if err != nil {
+		log.Printf("error updating message: %v", err)
+		return
+	}
+	bs.notifyOutgoing(edit.Text, OutgoingUpdate)
+}
+
+func (bs *session[T]) updateCaption(messageId MessageId, text string) {
+	edit := tgbotapi.NewEditMessageCaption(int64(bs.chatId), int(messageId), text)
+	edit.ParseMode = "html"
 
 	_, err := bs.botApi.Request(edit)
 	if err != nil {
-		log.Printf("error updating message: %v", err)
+		log.Printf("error updating caption: %v", err)
+	}
+}
+
+func (bs *session[T]) updateKeyboard(messageId MessageId, keyboard InlineKeyboard) {
+	if len(keyboard) == 0 {
+		bs.RemoveKeyboardForMessage(messageId)
+		return
+	}
+
+	markup, webApp, err := editMarkup(keyboard)
+	if err != nil {
+		log.Printf("error updating keyboard: %v", err)
+		return
+	}
+	if webApp {
+		err = bs.editWebAppKeyboard(messageId, keyboard, "", "")
+	} else {
+		_, err = bs.botApi.Request(tgbotapi.NewEditMessageReplyMarkup(int64(bs.chatId), int(messageId), *markup))
+	}
+	if err != nil {
+		log.Printf("error updating keyboard: %v", err)
 	}
-	bs.botApi.Request(tgbotapi.NewCallback(queryId, ""))
 }
 
 func (bs *session[T]) c(err error) {
@@ -402,9 +1314,43 @@ func (bs *session[T]) c(err error) {
 }
 
 func (bs *session[T]) Fail(message string, formatErrorMsg string, args ...interface{}) {
+	bs.FailStay(message, formatErrorMsg, args...)
+	if len(bs.stateStack) > 1 {
+		bs.PopState()
+	}
+}
+
+func (bs *session[T]) FailStay(message string, formatErrorMsg string, args ...interface{}) {
 	log.Printf(formatErrorMsg, args...)
 	bs.SendMessage(message)
-	bs.PopState()
+}
+
+// sendHelp sends the current state's help, falling back to the config's HelpText,
+// followed by the buttons of the state's keyboard
+func (bs *session[T]) sendHelp() {
+	var help string
+	if helpState, ok := bs.CurrentState().(HelpState); ok {
+		help = helpState.Help()
+	}
+	if help == "" {
+		help = bs.bot.config.HelpText
+	}
+	if help == "" {
+		help = "No help available."
+	}
+
+	if bs.stateKeyboard != nil {
+		var buttons []string
+		for _, row := range bs.stateKeyboard.Buttons() {
+			for _, button := range row {
+				buttons = append(buttons, "• "+html.EscapeString(string(button)))
+			}
+		}
+		if len(buttons) > 0 {
+			help += "\n\nButtons:\n" + strings.Join(buttons, "\n")
+		}
+	}
+	bs.SendMessage(help, SendMessageKeepKeyboard())
 }
 
 func (bs *session[T]) BotName() (string, error) {
@@ -415,10 +1361,15 @@ func (bs *session[T]) BotName() (string, error) {
 	return me.UserName, nil
 }
 
+func (bs *session[T]) BotStats() BotStats {
+	return bs.bot.Stats()
+}
+
 func (bs *session[T]) Shutdown() {
 	for i := len(bs.stateStack) - 1; i >= 0; i-- {
 		bs.stateStack[i].BeforeLeave(bs)
 	}
+	bs.cancelOperations()
 }
 
 func convertToMarkup(keyboard InlineKeyboard) *tgbotapi.InlineKeyboardMarkup {
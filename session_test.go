@@ -0,0 +1,414 @@
+package botty
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testGroup ChatId = -100
+
+func groupMessage(userId UserId, text string) tgbotapi.Update {
+	return tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			From: &tgbotapi.User{ID: int64(userId)},
+			Chat: &tgbotapi.Chat{ID: int64(testGroup), Type: "group"},
+			Text: text,
+		},
+	}
+}
+
+func groupPress(userId UserId, messageId int, data string) tgbotapi.Update {
+	return tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   "query",
+			From: &tgbotapi.User{ID: int64(userId)},
+			Message: &tgbotapi.Message{
+				MessageID: messageId,
+				Chat:      &tgbotapi.Chat{ID: int64(testGroup), Type: "group"},
+			},
+			Data: data,
+		},
+	}
+}
+
+func TestInlineMessageOwner(t *testing.T) {
+	var pressedBy []UserId
+	button := NewInlineButton("press", "press")
+
+	for _, tc := range []struct {
+		name      string
+		ownerOnly func(sb *StateBuilder[int]) *StateBuilder[int]
+		sendOpts  []SendMessageOption
+	}{
+		{
+			name:      "message option",
+			ownerOnly: func(sb *StateBuilder[int]) *StateBuilder[int] { return sb },
+			sendOpts:  []SendMessageOption{SendMessageOwnerOnly()},
+		},
+		{
+			name:      "state option",
+			ownerOnly: func(sb *StateBuilder[int]) *StateBuilder[int] { return sb.CallbacksOwnerOnly() },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pressedBy = nil
+			root := func() State[int] {
+				return tc.ownerOnly(NewStateBuilder[int]()).
+					OnActivate(func(bs Session[int]) {}).
+					OnMessage(func(bs Session[int], msg ChatMessage) {
+						bs.SendMessage("buttons of "+msg.Text(), append(tc.sendOpts, SendMessageInlineKeyboard(NewInlineKeyboard(NewInlineRow(button))))...)
+					}).
+					OnInlineButton(button, func(bs Session[int], query CallbackQuery) bool {
+						pressedBy = append(pressedBy, query.From())
+						return true
+					}).
+					Build()
+			}
+			mock := newTestMock(t, newTestConfig(root, 1, 2))
+
+			// user 1 creates the group's session, user 2 triggers the message
+			mock.sendUpdate(groupMessage(1, "one"))
+			mock.sendUpdate(groupMessage(2, "two"))
+			messageId := mock.LastMessageID
+
+			mock.bot.mSessions.Lock()
+			session, ok := mock.bot.sessions[testGroup]
+			mock.bot.mSessions.Unlock()
+			if !ok {
+				t.Fatalf("no session")
+			}
+			if owner, ok := session.InlineMessageOwner(MessageId(messageId)); !ok || owner != 2 {
+				t.Errorf("expected owner 2, got %d (tracked: %t)", owner, ok)
+			}
+
+			mock.sendUpdate(groupPress(1, messageId, button.Data))
+			mock.sendUpdate(groupPress(2, messageId, button.Data))
+			if len(pressedBy) != 1 || pressedBy[0] != 2 {
+				t.Errorf("expected only the owner to press the button, pressed by %v", pressedBy)
+			}
+		})
+	}
+}
+
+func TestTimeZoneIsPersisted(t *testing.T) {
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				if msg.Text() == "berlin" {
+					berlin, err := time.LoadLocation("Europe/Berlin")
+					if err != nil {
+						t.Errorf("error loading time zone: %v", err)
+					}
+					bs.SetTimeZone(berlin)
+				}
+				bs.SendMessage(bs.TimeZone().String())
+			}).
+			Build()
+	}
+	cfg := newTestConfig(root, 1)
+	mock := newTestMock(t, cfg)
+	mock.SendAndWait(1, "berlin")
+	// stopping stores the sessions
+	mock.Stop()
+
+	restarted := newTestMock(t, cfg)
+	restarted.SendAndWait(1, "zone")
+	if text := restarted.LastMessageText(); text != "Europe/Berlin" {
+		t.Errorf("expected the time zone to be loaded, got %s", text)
+	}
+}
+
+func TestCanLeaveVetoesAllTransitions(t *testing.T) {
+	var (
+		canLeave bool
+		leaves   int
+	)
+	locked := NewStateBuilder[int]().
+		OnActivate(func(bs Session[int]) {}).
+		OnCanLeave(func(bs Session[int]) bool { return canLeave }).
+		OnBeforeLeave(func(bs Session[int]) {
+			if !canLeave {
+				leaves++
+			}
+		}).
+		Build()
+	other := NewStateBuilder[int]().OnActivate(func(bs Session[int]) {}).Build()
+
+	transitions := map[string]func(bs Session[int]){
+		"PushState":    func(bs Session[int]) { bs.PushState(other) },
+		"PopState":     func(bs Session[int]) { bs.PopState() },
+		"DropStates":   func(bs Session[int]) { bs.DropStates(1) },
+		"ReplaceState": func(bs Session[int]) { bs.ReplaceState(other) },
+		"ResetToState": func(bs Session[int]) { bs.ResetToState(other) },
+		"Reenter":      func(bs Session[int]) { bs.Reenter() },
+	}
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				bs.PushState(locked)
+				canLeave = false
+				transitions[msg.Text()](bs)
+				if bs.CurrentState() != locked {
+					t.Errorf("%s left the state", msg.Text())
+				}
+				canLeave = true
+				bs.ResetToState(bs.RootState())
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+
+	for name := range transitions {
+		mock.SendAndWait(1, name)
+	}
+	if leaves != 0 {
+		t.Errorf("BeforeLeave was called %d times", leaves)
+	}
+}
+
+func TestReloadLeavesTheState(t *testing.T) {
+	root := func() State[int] {
+		return NewMessageHandler(func(bs Session[int], data string) (string, InlineKeyboard, error) {
+			return "menu", NewInlineKeyboard(NewInlineRow(NewInlineButton("press", "press"))), nil
+		})
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+	mock.SendAndWait(1, "hi")
+	menuId := MessageId(mock.LastMessageID)
+
+	// the message handler removes its keyboard when left, so reloading doesn't leave a stale one
+	mock.SendCommand(1, "reload")
+	var removed []MessageId
+	for _, request := range mock.Requests() {
+		if edit, ok := request.(tgbotapi.EditMessageReplyMarkupConfig); ok {
+			removed = append(removed, MessageId(edit.MessageID))
+		}
+	}
+	if len(removed) != 1 || removed[0] != menuId {
+		t.Errorf("expected the keyboard of message %d to be removed, removed %v", menuId, removed)
+	}
+}
+
+func TestReenterLeavesTheState(t *testing.T) {
+	var activations, leaves int
+	menu := func() State[int] {
+		return NewMessageHandler(func(bs Session[int], data string) (string, InlineKeyboard, error) {
+			return "menu", NewInlineKeyboard(NewInlineRow(NewInlineButton("press", "press"))), nil
+		})
+	}
+	counting := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) { activations++ }).
+			OnBeforeLeave(func(bs Session[int]) { leaves++ }).
+			Build()
+	}
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				switch msg.Text() {
+				case "menu":
+					bs.PushState(menu())
+					bs.Reenter()
+				case "counting":
+					bs.PushState(counting())
+					bs.Reenter()
+					bs.ReplaceState(counting())
+				}
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1, 2))
+
+	// the first menu's keyboard is removed, so only the re-entered one remains
+	mock.SendAndWait(1, "menu")
+	var removed []MessageId
+	for _, request := range mock.Requests() {
+		if edit, ok := request.(tgbotapi.EditMessageReplyMarkupConfig); ok {
+			removed = append(removed, MessageId(edit.MessageID))
+		}
+	}
+	if lastId := MessageId(mock.LastMessageID); len(removed) != 1 || removed[0] == lastId {
+		t.Errorf("expected only the keyboard of the first menu to be removed, removed %v (last message %d)", removed, lastId)
+	}
+
+	mock.SendAndWait(2, "counting")
+	if activations != 3 || leaves != 2 {
+		t.Errorf("expected 3 activations and 2 leaves, got %d and %d", activations, leaves)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	var opts []SendMessageOption
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				if msg.Text() == "template" {
+					bs.SendTemplateMessage(`{{.text}}`, KeyValues{KV("text", "*bold*")}, opts...)
+					return
+				}
+				bs.SendMessage("*bold*", opts...)
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+
+	for _, mode := range []string{ParseModeHTML, ParseModeMarkdownV2, ParseModeNone} {
+		opts = []SendMessageOption{SendMessageParseMode(mode)}
+		for _, text := range []string{"message", "template"} {
+			mock.SendAndWait(1, text)
+			if got := mock.LastMessage.ParseMode; got != mode {
+				t.Errorf("%s: expected parse mode %q, got %q", text, mode, got)
+			}
+		}
+	}
+
+	// defaults to html
+	opts = nil
+	mock.SendAndWait(1, "message")
+	if got := mock.LastMessage.ParseMode; got != ParseModeHTML {
+		t.Errorf("expected parse mode html by default, got %q", got)
+	}
+}
+
+func TestInlineMessageCallback(t *testing.T) {
+	var queries []CallbackQuery
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnCallbackQuery(func(bs Session[int], query CallbackQuery) bool {
+				queries = append(queries, query)
+				return true
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+
+	// callbacks of messages sent via inline mode have no message
+	mock.sendUpdate(tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:              "query",
+			From:            &tgbotapi.User{ID: 1},
+			InlineMessageID: "inline-42",
+			Data:            "data",
+		},
+	})
+
+	if len(queries) != 1 {
+		t.Fatalf("expected the callback to be routed to the user's session, got %d queries", len(queries))
+	}
+	query := queries[0]
+	if query.InlineMessageID() != "inline-42" || query.MessageID() != 0 || query.Data() != "data" {
+		t.Errorf("unexpected query: inline message %q, message %d, data %q", query.InlineMessageID(), query.MessageID(), query.Data())
+	}
+	if _, ok := mock.bot.SessionForUser(1); !ok {
+		t.Errorf("expected a session for the user's private chat")
+	}
+}
+
+func TestEditedMessages(t *testing.T) {
+	var edited []ChatMessage
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnEditedMessage(func(bs Session[int], msg ChatMessage) {
+				edited = append(edited, msg)
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+	mock.SendAndWait(1, "hi")
+
+	mock.sendUpdate(tgbotapi.Update{
+		EditedMessage: &tgbotapi.Message{
+			MessageID: 42,
+			From:      &tgbotapi.User{ID: 1},
+			Chat:      &tgbotapi.Chat{ID: 1},
+			Text:      "hello",
+		},
+	})
+	if len(edited) != 1 || edited[0].MessageID() != 42 || edited[0].Text() != "hello" {
+		t.Errorf("expected the edited message to be handled, got %v", edited)
+	}
+}
+
+// minimalState implements none of the optional state interfaces
+type minimalState struct {
+	activations int
+}
+
+func (s *minimalState) Activate(bs Session[int])                            { s.activations++ }
+func (s *minimalState) Return(bs Session[int])                              {}
+func (s *minimalState) HandleMessage(bs Session[int], msg ChatMessage) bool { return false }
+func (s *minimalState) HandleCommand(bs Session[int], command string, args ...string) bool {
+	return false
+}
+func (s *minimalState) HandleCallbackQuery(bs Session[int], query CallbackQuery) bool { return false }
+func (s *minimalState) BeforeLeave(bs Session[int])                                   {}
+
+func TestStatesWithoutLeaveGuardCanBeLeft(t *testing.T) {
+	minimal := &minimalState{}
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				bs.PushState(minimal)
+				bs.PushState(NewStateBuilder[int]().OnActivate(func(bs Session[int]) {}).Build())
+				bs.PopState()
+				bs.ReplaceState(minimal)
+				bs.ResetToState(bs.RootState())
+			}).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+	mock.SendAndWait(1, "hi")
+
+	if minimal.activations != 2 {
+		t.Errorf("expected the state to be activated twice, got %d", minimal.activations)
+	}
+	done := make(chan struct{})
+	mock.bot.Do(1, func(bs Session[int]) {
+		defer close(done)
+		if bs.StackDepth() != 1 || bs.CurrentState() == State[int](minimal) {
+			t.Errorf("expected to be back at the root state, stack depth %d", bs.StackDepth())
+		}
+	})
+	<-done
+}
+
+func TestOutgoingTextsArriveInOrder(t *testing.T) {
+	root := func() State[int] {
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {}).
+			OnMessage(func(bs Session[int], msg ChatMessage) {
+				for i := range 10 {
+					bs.SendMessage(fmt.Sprintf("text %d", i))
+				}
+			}).
+			Build()
+	}
+	outgoing := make(chan string, 10)
+	cfg := newTestConfig(root, 1)
+	cfg.OnOutgoing = func(chatId ChatId, text string, kind string) {
+		outgoing <- text
+	}
+	mock := newTestMock(t, cfg)
+	mock.SendAndWait(1, "send")
+
+	for i := range 10 {
+		select {
+		case text := <-outgoing:
+			if expected := fmt.Sprintf("text %d", i); text != expected {
+				t.Errorf("expected %s, got %s", expected, text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for outgoing text %d", i)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package botty
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
 type (
 	Button         string
 	ButtonRow      []Button
@@ -34,12 +41,112 @@ type State[T any] interface {
 	BeforeLeave(bs Session[T])
 }
 
+// buttonRequester is implemented by keyboards whose buttons request data from the user
+type buttonRequester interface {
+	requestsContact(button Button) bool
+	requestsLocation(button Button) bool
+	webAppURL(button Button) string
+}
+
+type requestKeyboard struct {
+	Keyboard
+	contact  map[Button]bool
+	location map[Button]bool
+	webApp   map[Button]string
+}
+
+func (rk *requestKeyboard) requestsContact(button Button) bool {
+	if rk.contact[button] {
+		return true
+	}
+	inner, ok := rk.Keyboard.(buttonRequester)
+	return ok && inner.requestsContact(button)
+}
+
+func (rk *requestKeyboard) requestsLocation(button Button) bool {
+	if rk.location[button] {
+		return true
+	}
+	inner, ok := rk.Keyboard.(buttonRequester)
+	return ok && inner.requestsLocation(button)
+}
+
+func (rk *requestKeyboard) webAppURL(button Button) string {
+	if url := rk.webApp[button]; url != "" {
+		return url
+	}
+	if inner, ok := rk.Keyboard.(buttonRequester); ok {
+		return inner.webAppURL(button)
+	}
+	return ""
+}
+
+// WithContactRequest flags the buttons of the keyboard to send the user's phone number when pressed.
+// The number is available via ChatMessage.Contact().
+func WithContactRequest(keyboard Keyboard, buttons ...Button) Keyboard {
+	rk := &requestKeyboard{Keyboard: keyboard, contact: map[Button]bool{}}
+	for _, button := range buttons {
+		rk.contact[button] = true
+	}
+	return rk
+}
+
+// WithLocationRequest flags the buttons of the keyboard to send the user's location when pressed.
+// The location is available via ChatMessage.Location().
+func WithLocationRequest(keyboard Keyboard, buttons ...Button) Keyboard {
+	rk := &requestKeyboard{Keyboard: keyboard, location: map[Button]bool{}}
+	for _, button := range buttons {
+		rk.location[button] = true
+	}
+	return rk
+}
+
+// NamedState can be implemented by states to provide a name for logging and debugging
+type NamedState interface {
+	Name() string
+}
+
+// HelpState can be implemented by states to provide contextual help, shown by /help
+type HelpState interface {
+	Help() string
+}
+
+// EditedMessageHandler can be implemented by states to handle messages edited by the user.
+// msg.MessageID() refers to the original message.
+type EditedMessageHandler[T any] interface {
+	HandleEditedMessage(bs Session[T], msg ChatMessage) bool
+}
+
+// LeaveGuard can be implemented by states to veto leaving them. CanLeave is called before pushing,
+// popping, replacing or resetting the state, returning false aborts the transition,
+// e.g. to ask the user to confirm discarding unsaved input. States not implementing it can always be left.
+type LeaveGuard[T any] interface {
+	CanLeave(bs Session[T]) bool
+}
+
 func NewButtonKeyboard(rows ...ButtonRow) Keyboard {
 	return buttonKeyboard(rows)
 }
 
 var NoButtons buttonKeyboard = nil
 
+// KeyboardFromStrings lays out the labels into rows of cols buttons.
+// Returns NoButtons if there are no labels.
+func KeyboardFromStrings(cols int, labels ...string) Keyboard {
+	if len(labels) == 0 {
+		return NoButtons
+	}
+	buttons := make([]Button, 0, len(labels))
+	for _, label := range labels {
+		buttons = append(buttons, Button(label))
+	}
+	var rows []ButtonRow
+	for _, row := range layoutRows(buttons, cols) {
+		rows = append(rows, ButtonRow(row))
+	}
+	return NewButtonKeyboard(rows...)
+}
+
 func NewConditionalRow(condition func() bool, row ButtonRow) ButtonRow {
 	if condition() {
 		return row
@@ -62,17 +169,81 @@ type (
 	InlineButton struct {
 		Label string
 		Data  string
+		// if set, the button opens the web app instead of sending Data, see NewInlineButtonWebApp
+		WebAppURL string
 	}
 	InlineRow      []InlineButton
 	InlineKeyboard []InlineRow
 )
 
+// MaxCallbackDataLength is the maximum size of an inline button's data in bytes, as defined by telegram
+const MaxCallbackDataLength = 64
+
+// Validate checks that all buttons' data fits telegram's limits
+func (k InlineKeyboard) Validate() error {
+	for _, row := range k {
+		for _, button := range row {
+			if len(button.Data) > MaxCallbackDataLength {
+				return fmt.Errorf("data of inline button '%s' exceeds %d bytes: %s", button.Label, MaxCallbackDataLength, button.Data)
+			}
+		}
+	}
+	return nil
+}
+
+// separates the parts of encoded callback data
+const callbackDataSeparator = ":"
+
+// EncodeCallbackData joins the parts to callback data for an inline button.
+// Parts must not contain ':' and the result must not exceed MaxCallbackDataLength bytes.
+func EncodeCallbackData(parts ...string) (string, error) {
+	for _, part := range parts {
+		if strings.Contains(part, callbackDataSeparator) {
+			return "", fmt.Errorf("callback data part '%s' must not contain '%s'", part, callbackDataSeparator)
+		}
+	}
+	data := strings.Join(parts, callbackDataSeparator)
+	if len(data) > MaxCallbackDataLength {
+		return "", fmt.Errorf("callback data exceeds %d bytes: %s", MaxCallbackDataLength, data)
+	}
+	return data, nil
+}
+
+// DecodeCallbackData splits callback data created by EncodeCallbackData into its parts
+func DecodeCallbackData(data string) []string {
+	return strings.Split(data, callbackDataSeparator)
+}
+
+// NewInlineKeyboard creates a keyboard of the rows, skipping empty rows
 func NewInlineKeyboard(rows ...InlineRow) InlineKeyboard {
-	return InlineKeyboard(rows)
+	var keyboard InlineKeyboard
+	for _, row := range rows {
+		if len(row) > 0 {
+			keyboard = append(keyboard, row)
+		}
+	}
+	return keyboard
 }
 
+// NewInlineRow creates a row of the buttons, skipping empty buttons
 func NewInlineRow(buttons ...InlineButton) InlineRow {
-	return InlineRow(buttons)
+	var row InlineRow
+	for _, button := range buttons {
+		if button != (InlineButton{}) {
+			row = append(row, button)
+		}
+	}
+	return row
+}
+
+// OptionalInlineButton returns the button if cond is set, otherwise nothing, e.g. for permission-gated buttons:
+//
+//	NewInlineRow(append(OptionalInlineButton(isAdmin, deleteButton), backButton)...)
+func OptionalInlineButton(cond bool, b InlineButton) []InlineButton {
+	if !cond {
+		return nil
+	}
+	return []InlineButton{b}
 }
 
 func NewInlineButton(label, data string) InlineButton {
@@ -82,6 +253,59 @@ func NewInlineButton(label, data string) InlineButton {
 	}
 }
 
+// callback data prefix of the navigation buttons of paged inline keyboards
+const pageCallbackPrefix = "_page:"
+
+// NewPagedInlineKeyboard creates a keyboard containing the page-th page (zero based) of buttons, laid out
+// in rows of cols buttons. If there is more than one page, a row with navigation buttons is appended,
+// whose data can be parsed with ParsePageCallback.
+func NewPagedInlineKeyboard(buttons []InlineButton, page, pageSize, cols int) InlineKeyboard {
+	if pageSize <= 0 {
+		pageSize = len(buttons)
+	}
+	if cols <= 0 {
+		cols = 1
+	}
+	numPages := max(1, (len(buttons)+pageSize-1)/pageSize)
+	page = min(max(page, 0), numPages-1)
+
+	pageButtons := buttons[min(page*pageSize, len(buttons)):min((page+1)*pageSize, len(buttons))]
+
+	var keyboard InlineKeyboard
+	for len(pageButtons) > 0 {
+		size := min(cols, len(pageButtons))
+		keyboard = append(keyboard, append(InlineRow(nil), pageButtons[:size]...))
+		pageButtons = pageButtons[size:]
+	}
+
+	if numPages > 1 {
+		var nav InlineRow
+		if page > 0 {
+			nav = append(nav, NewInlineButton("◀", pageCallbackPrefix+strconv.Itoa(page-1)))
+		}
+		nav = append(nav, NewInlineButton(fmt.Sprintf("%d/%d", page+1, numPages), pageCallbackPrefix+strconv.Itoa(page)))
+		if page < numPages-1 {
+			nav = append(nav, NewInlineButton("▶", pageCallbackPrefix+strconv.Itoa(page+1)))
+		}
+		keyboard = append(keyboard, nav)
+	}
+	return keyboard
+}
+
+// ParsePageCallback returns the page selected by a navigation button of a paged inline keyboard.
+// Returns false if the data does not belong to a navigation button.
+func ParsePageCallback(data string) (int, bool) {
+	pageData, ok := strings.CutPrefix(data, pageCallbackPrefix)
+	if !ok {
+		return 0, false
+	}
+	page, err := strconv.Atoi(pageData)
+	if err != nil {
+		return 0, false
+	}
+	return page, true
+}
+
 type InlineButtonAction[T any] struct {
 	Label  string
 	Data   string
@@ -99,6 +323,11 @@ func NewInlineButtonAction[T any](label, data string, action func(param T) error
 type DynamicKeyboard[T any] struct {
 	handlers map[Button]func(bs Session[T])
 	rows     []ButtonRow
+
+	// if set, the next added button starts a new row
+	endRow bool
+
+	onHandled func(bs Session[T], button Button)
 }
 
 func NewDynamicKeyboard[T any]() *DynamicKeyboard[T] {
@@ -109,8 +338,9 @@ func NewDynamicKeyboard[T any]() *DynamicKeyboard[T] {
 
 func (d *DynamicKeyboard[T]) AddButton(label string, handler func(bs Session[T]), startRowAfter int) {
 	d.handlers[Button(label)] = handler
-	if len(d.rows) == 0 {
+	if len(d.rows) == 0 || d.endRow {
 		d.rows = append(d.rows, NewRow(Button(label)))
+		d.endRow = false
 	} else {
 		last := d.rows[len(d.rows)-1]
 
@@ -123,34 +353,308 @@ func (d *DynamicKeyboard[T]) AddButton(label string, handler func(bs Session[T])
 	}
 }
 
+// RemoveButton removes the button and its handler. Rows that end up empty are dropped.
+func (d *DynamicKeyboard[T]) RemoveButton(label string) {
+	delete(d.handlers, Button(label))
+
+	var rows []ButtonRow
+	for _, row := range d.rows {
+		var newRow ButtonRow
+		for _, button := range row {
+			if button != Button(label) {
+				newRow = append(newRow, button)
+			}
+		}
+		if len(newRow) > 0 {
+			rows = append(rows, newRow)
+		}
+	}
+	d.rows = rows
+}
+
+// EndRow ends the current row, so the next added button starts a new one.
+func (d *DynamicKeyboard[T]) EndRow() {
+	d.endRow = true
+}
+
 func (d *DynamicKeyboard[T]) Reset() {
 	d.handlers = map[Button]func(bs Session[T]){}
 	d.rows = nil
+	d.endRow = false
 }
 
+// Handle runs the handler of the button and returns whether there was one.
+// Buttons added without handler are not handled.
 func (d *DynamicKeyboard[T]) Handle(bs Session[T], button Button) bool {
-	handler, ok := d.handlers[button]
+	handler := d.handlers[button]
+	if handler == nil {
+		return false
+	}
+	handler(bs)
+	if d.onHandled != nil {
+		d.onHandled(bs, button)
+	}
+	return true
+}
+
+// OnHandled sets a callback that is called after Handle ran the handler of a button,
+// e.g. to log which buttons of a data-driven menu are used. It's kept on Reset.
+func (d *DynamicKeyboard[T]) OnHandled(onHandled func(bs Session[T], button Button)) {
+	d.onHandled = onHandled
+}
+
+// BalancedLayout rearranges all buttons into rows of about cols buttons, spreading the remainder over
+// the rows instead of leaving a lonely last button, e.g. 5 buttons at 2 cols gives 3/2 instead of 2/2/1
+// and 7 buttons at 3 cols gives 4/3. Rows hold at most cols+1 buttons.
+func (d *DynamicKeyboard[T]) BalancedLayout(cols int) {
+	var buttons []Button
+	for _, row := range d.rows {
+		buttons = append(buttons, row...)
+	}
+	d.rows = balanceRows(buttons, cols)
+}
+
+// AutoLayoutByWidth rearranges all buttons into rows, filling each row left to right as long as the
+// labels' total length in characters does not exceed maxWidth. A label longer than maxWidth gets a row
+// of its own, see InlineKeyHandler.AutoLayoutByWidth.
+func (d *DynamicKeyboard[T]) AutoLayoutByWidth(maxWidth int) {
+	var buttons []Button
+	for _, row := range d.rows {
+		buttons = append(buttons, row...)
+	}
+	d.rows = nil
+	for _, row := range layoutRowsByWidth(buttons, func(button Button) int {
+		return utf8.RuneCountInString(string(button))
+	}, maxWidth) {
+		d.rows = append(d.rows, ButtonRow(row))
+	}
+}
+
+func balanceRows(buttons []Button, cols int) []ButtonRow {
+	if len(buttons) == 0 {
+		return nil
+	}
+	if cols <= 0 || len(buttons) <= cols {
+		return []ButtonRow{append(ButtonRow(nil), buttons...)}
+	}
+
+	numRows := len(buttons) / cols
+	if len(buttons)%cols != 0 {
+		// as few rows as possible with up to one extra button each
+		numRows = (len(buttons) + cols) / (cols + 1)
+	}
+	perRow, extra := len(buttons)/numRows, len(buttons)%numRows
+
+	rows := make([]ButtonRow, 0, numRows)
+	for i := 0; i < numRows; i++ {
+		size := perRow
+		if i < extra {
+			size++
+		}
+		rows = append(rows, append(ButtonRow(nil), buttons[:size]...))
+		buttons = buttons[size:]
+	}
+	return rows
+}
+
+// InlineKeyboard converts the buttons to inline buttons, using the label as data.
+// Pressed buttons can be dispatched by passing Button(query.Data()) to Handle.
+func (d *DynamicKeyboard[T]) InlineKeyboard() InlineKeyboard {
+	var keyboard InlineKeyboard
+	for _, row := range d.rows {
+		var inlineRow InlineRow
+		for _, button := range row {
+			inlineRow = append(inlineRow, NewInlineButton(string(button), string(button)))
+		}
+		if len(inlineRow) > 0 {
+			keyboard = append(keyboard, inlineRow)
+		}
+	}
+	return keyboard
+}
+
+// Rows returns a copy of the keyboard's rows
+func (d *DynamicKeyboard[T]) Rows() []ButtonRow {
+	rows := make([]ButtonRow, 0, len(d.rows))
+	for _, row := range d.rows {
+		rows = append(rows, append(ButtonRow(nil), row...))
+	}
+	return rows
+}
+
+// InlineKeyHandler builds an inline keyboard and dispatches callback queries to the
+// handler registered for the pressed button's data.
+type InlineKeyHandler[T any] struct {
+	handlers map[string]func(bs Session[T], query CallbackQuery)
+	rows     []InlineRow
+
+	// layout of the last PagedKeyboard, to render the pages selected by the navigation buttons
+	pageSize, pageCols int
+}
+
+func NewInlineKeyHandler[T any]() *InlineKeyHandler[T] {
+	return &InlineKeyHandler[T]{
+		handlers: map[string]func(bs Session[T], query CallbackQuery){},
+	}
+}
+
+// AddButton adds a button to the current row
+func (ih *InlineKeyHandler[T]) AddButton(label, data string, handler func(bs Session[T], query CallbackQuery)) *InlineKeyHandler[T] {
+	ih.handlers[data] = handler
+	if len(ih.rows) == 0 {
+		ih.rows = append(ih.rows, nil)
+	}
+	ih.rows[len(ih.rows)-1] = append(ih.rows[len(ih.rows)-1], NewInlineButton(label, data))
+	return ih
+}
+
+// NextRow starts a new row for subsequently added buttons
+func (ih *InlineKeyHandler[T]) NextRow() *InlineKeyHandler[T] {
+	if len(ih.rows) > 0 && len(ih.rows[len(ih.rows)-1]) > 0 {
+		ih.rows = append(ih.rows, nil)
+	}
+	return ih
+}
+
+// AutoLayout rearranges all buttons into rows of cols buttons, filling left to right.
+func (ih *InlineKeyHandler[T]) AutoLayout(cols int) *InlineKeyHandler[T] {
+	var buttons []InlineButton
+	for _, row := range ih.rows {
+		buttons = append(buttons, row...)
+	}
+	ih.rows = nil
+	for _, row := range layoutRows(buttons, cols) {
+		ih.rows = append(ih.rows, InlineRow(row))
+	}
+	return ih
+}
+
+// AutoLayoutByWidth rearranges all buttons into rows, filling each row left to right as long as the
+// labels' total length in characters does not exceed maxWidth. A label longer than maxWidth gets a row
+// of its own. Unlike AutoLayout, this keeps long labels from being squeezed on small screens.
+func (ih *InlineKeyHandler[T]) AutoLayoutByWidth(maxWidth int) *InlineKeyHandler[T] {
+	var buttons []InlineButton
+	for _, row := range ih.rows {
+		buttons = append(buttons, row...)
+	}
+	ih.rows = nil
+	for _, row := range layoutRowsByWidth(buttons, func(button InlineButton) int {
+		return utf8.RuneCountInString(button.Label)
+	}, maxWidth) {
+		ih.rows = append(ih.rows, InlineRow(row))
+	}
+	return ih
+}
+
+// layoutRowsByWidth splits the buttons into rows whose total width does not exceed maxWidth,
+// except for rows with a single button wider than maxWidth.
+func layoutRowsByWidth[B any](buttons []B, width func(B) int, maxWidth int) [][]B {
+	var (
+		rows     [][]B
+		row      []B
+		rowWidth int
+	)
+	for _, button := range buttons {
+		w := width(button)
+		if len(row) > 0 && rowWidth+w > maxWidth {
+			rows = append(rows, row)
+			row, rowWidth = nil, 0
+		}
+		row = append(row, button)
+		rowWidth += w
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// layoutRows splits the buttons into rows of cols buttons, filling left to right.
+// cols <= 0 puts all buttons into one row.
+func layoutRows[B any](buttons []B, cols int) [][]B {
+	if cols <= 0 {
+		cols = len(buttons)
+	}
+
+	var rows [][]B
+	for len(buttons) > 0 {
+		size := min(cols, len(buttons))
+		rows = append(rows, append([]B(nil), buttons[:size]...))
+		buttons = buttons[size:]
+	}
+	return rows
+}
+
+// Keyboard returns the inline keyboard to be sent with a message
+func (ih *InlineKeyHandler[T]) Keyboard() InlineKeyboard {
+	var keyboard InlineKeyboard
+	for _, row := range ih.rows {
+		if len(row) > 0 {
+			keyboard = append(keyboard, append(InlineRow(nil), row...))
+		}
+	}
+	return keyboard
+}
+
+// PagedKeyboard returns one page of the handler's buttons, see NewPagedInlineKeyboard.
+// Pressing a navigation button replaces the message's keyboard with the selected page,
+// laid out like the last paged keyboard.
+func (ih *InlineKeyHandler[T]) PagedKeyboard(page, pageSize, cols int) InlineKeyboard {
+	ih.pageSize, ih.pageCols = pageSize, cols
+	var buttons []InlineButton
+	for _, row := range ih.rows {
+		buttons = append(buttons, row...)
+	}
+	return NewPagedInlineKeyboard(buttons, page, pageSize, cols)
+}
+
+func (ih *InlineKeyHandler[T]) handle(bs Session[T], query CallbackQuery) bool {
+	handler, ok := ih.handlers[query.Data()]
 	if ok {
-		handler(bs)
+		handler(bs, query)
+		return true
+	}
+	if page, ok := ParsePageCallback(query.Data()); ok && ih.pageSize > 0 && query.MessageID() != 0 {
+		editor, ok := bs.(messageEditor)
+		if !ok {
+			return false
+		}
+		msg := &message{messageId: int(query.MessageID()), editor: editor}
+		msg.UpdateKeyboard(ih.PagedKeyboard(page, ih.pageSize, ih.pageCols))
+		editor.answerCallback(query.ID())
 		return true
 	}
 	return false
 }
 
-func (d *DynamicKeyboard[T]) Rows() []ButtonRow {
-	// TODO: make a copy
-	return d.rows
-}
+var _ State[any] = (*functionState[any])(nil)
 
 type functionState[T any] struct {
+	name                 string
+	help                 string
 	activate             func(bs Session[T])
 	returner             func(bs Session[T])
 	handleMessage        func(bs Session[T], message ChatMessage)
+	editedMessageHandler func(bs Session[T], message ChatMessage)
 	buttonHandler        map[Button]func(bs Session[T], message ChatMessage)
 	commandHandler       func(bs Session[T], command string, args ...string) bool
 	callbackQueryHandler func(bs Session[T], query CallbackQuery) bool
 	queryDataHandler     map[string]func(bs Session[T], query CallbackQuery) bool
+	queryPrefixHandler   map[string]func(bs Session[T], query CallbackQuery) bool
 	beforeLeaveHandler   func(bs Session[T])
+	canLeaveHandler      func(bs Session[T]) bool
+	inlineKeyHandlers    []*InlineKeyHandler[T]
+
+	// only the user that triggered an inline message may press its buttons
+	callbacksOwnerOnly bool
+}
+
+func (fs *functionState[T]) Name() string {
+	return fs.name
+}
+
+func (fs *functionState[T]) Help() string {
+	return fs.help
 }
 
 func (fs *functionState[T]) Activate(bs Session[T]) {
@@ -179,6 +683,14 @@ func (fs *functionState[T]) HandleMessage(bs Session[T], message ChatMessage) bo
 	return true
 }
 
+func (fs *functionState[T]) HandleEditedMessage(bs Session[T], message ChatMessage) bool {
+	if fs.editedMessageHandler == nil {
+		return false
+	}
+	fs.editedMessageHandler(bs, message)
+	return true
+}
+
 func (fs *functionState[T]) HandleCommand(bs Session[T], command string, args ...string) bool {
 	if fs.commandHandler != nil {
 		return fs.commandHandler(bs, command, args...)
@@ -187,21 +699,61 @@ func (fs *functionState[T]) HandleCommand(bs Session[T], command string, args ..
 }
 
 func (fs *functionState[T]) HandleCallbackQuery(bs Session[T], query CallbackQuery) bool {
+	if fs.callbacksOwnerOnly {
+		// messages sent before a restart are not tracked anymore
+		owner, ok := bs.InlineMessageOwner(query.MessageID())
+		if !ok {
+			owner = bs.UserId()
+		}
+		if query.From() != owner {
+			bs.AnswerCallbackQuery(query.ID(), "not for you", true)
+			return true
+		}
+	}
 	if handler, ok := fs.queryDataHandler[query.Data()]; ok {
 		return handler(bs, query)
 	}
+	if handler := fs.prefixHandler(query.Data()); handler != nil {
+		return handler(bs, query)
+	}
+	for _, keyHandler := range fs.inlineKeyHandlers {
+		if keyHandler.handle(bs, query) {
+			return true
+		}
+	}
 	if fs.callbackQueryHandler != nil {
 		return fs.callbackQueryHandler(bs, query)
 	}
 	return false
 }
 
+// prefixHandler returns the handler with the longest prefix matching the data
+func (fs *functionState[T]) prefixHandler(data string) func(bs Session[T], query CallbackQuery) bool {
+	var (
+		handler   func(bs Session[T], query CallbackQuery) bool
+		prefixLen = -1
+	)
+	for prefix, prefixHandler := range fs.queryPrefixHandler {
+		if len(prefix) > prefixLen && strings.HasPrefix(data, prefix) {
+			handler, prefixLen = prefixHandler, len(prefix)
+		}
+	}
+	return handler
+}
+
 func (fs *functionState[T]) BeforeLeave(bs Session[T]) {
 	if fs.beforeLeaveHandler != nil {
 		fs.beforeLeaveHandler(bs)
 	}
 }
 
+func (fs *functionState[T]) CanLeave(bs Session[T]) bool {
+	if fs.canLeaveHandler != nil {
+		return fs.canLeaveHandler(bs)
+	}
+	return true
+}
+
 type StateBuilder[T any] struct {
 	fs *functionState[T]
 }
@@ -209,12 +761,25 @@ type StateBuilder[T any] struct {
 func NewStateBuilder[T any]() *StateBuilder[T] {
 	return &StateBuilder[T]{
 		fs: &functionState[T]{
-			buttonHandler:    make(map[Button]func(bs Session[T], message ChatMessage)),
-			queryDataHandler: make(map[string]func(bs Session[T], query CallbackQuery) bool),
+			buttonHandler:      make(map[Button]func(bs Session[T], message ChatMessage)),
+			queryDataHandler:   make(map[string]func(bs Session[T], query CallbackQuery) bool),
+			queryPrefixHandler: make(map[string]func(bs Session[T], query CallbackQuery) bool),
 		},
 	}
 }
 
+// Named sets the state's name, which is used for logging and debugging
+func (sb *StateBuilder[T]) Named(name string) *StateBuilder[T] {
+	sb.fs.name = name
+	return sb
+}
+
+// WithHelp sets the text /help shows while the state is active
+func (sb *StateBuilder[T]) WithHelp(text string) *StateBuilder[T] {
+	sb.fs.help = text
+	return sb
+}
+
 func (sb *StateBuilder[T]) OnActivate(activator func(bs Session[T])) *StateBuilder[T] {
 	sb.fs.activate = activator
 	return sb
@@ -225,6 +790,11 @@ func (sb *StateBuilder[T]) OnMessage(handleMessage func(bs Session[T], message C
 	return sb
 }
 
+func (sb *StateBuilder[T]) OnEditedMessage(handler func(bs Session[T], message ChatMessage)) *StateBuilder[T] {
+	sb.fs.editedMessageHandler = handler
+	return sb
+}
+
 func (sb *StateBuilder[T]) OnButton(button Button, handler func(bs Session[T], message ChatMessage)) *StateBuilder[T] {
 	sb.fs.buttonHandler[button] = handler
 	// TODO handle the button in the handler
@@ -232,7 +802,12 @@ func (sb *StateBuilder[T]) OnButton(button Button, handler func(bs Session[T], m
 }
 
 func (sb *StateBuilder[T]) OnBeforeLeave(handler func(bs Session[T])) *StateBuilder[T] {
-	// TODO	implement
+	sb.fs.beforeLeaveHandler = handler
+	return sb
+}
+
+func (sb *StateBuilder[T]) OnCanLeave(handler func(bs Session[T]) bool) *StateBuilder[T] {
+	sb.fs.canLeaveHandler = handler
 	return sb
 }
 
@@ -246,6 +821,28 @@ func (sb *StateBuilder[T]) OnInlineButton(button InlineButton, handler func(bs S
 	return sb
 }
 
+// OnCallbackPrefix handles callback queries whose data starts with prefix, e.g. "page:".
+// This allows hosting multiple independent inline widgets in one state, see EncodeCallbackData.
+// Exact matches registered by OnInlineButton take precedence, then the longest matching prefix.
+func (sb *StateBuilder[T]) OnCallbackPrefix(prefix string, handler func(bs Session[T], query CallbackQuery) bool) *StateBuilder[T] {
+	sb.fs.queryPrefixHandler[prefix] = handler
+	return sb
+}
+
+// CallbacksOwnerOnly restricts the inline buttons handled by the state to the user whose update
+// triggered sending the message, see Session.InlineMessageOwner. Other users pressing a button,
+// e.g. in a group chat, get an alert. See SendMessageOwnerOnly to restrict single messages.
+func (sb *StateBuilder[T]) CallbacksOwnerOnly() *StateBuilder[T] {
+	sb.fs.callbacksOwnerOnly = true
+	return sb
+}
+
+// AddInlineKeyHandler lets the state dispatch callback queries to the key handler's buttons.
+func (sb *StateBuilder[T]) AddInlineKeyHandler(keyHandler *InlineKeyHandler[T]) *StateBuilder[T] {
+	sb.fs.inlineKeyHandlers = append(sb.fs.inlineKeyHandlers, keyHandler)
+	return sb
+}
+
 func (sb *StateBuilder[T]) Build() State[T] {
 	if sb.fs.activate == nil {
 		sb.fs.activate = func(bs Session[T]) {
@@ -0,0 +1,280 @@
+package botty
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// rowSizes returns the number of buttons per row
+func rowSizes(rows []ButtonRow) []int {
+	var sizes []int
+	for _, row := range rows {
+		sizes = append(sizes, len(row))
+	}
+	return sizes
+}
+
+func TestDynamicKeyboardBalancedLayout(t *testing.T) {
+	for _, tc := range []struct {
+		buttons int
+		cols    int
+		sizes   []int
+	}{
+		{buttons: 5, cols: 2, sizes: []int{3, 2}},
+		{buttons: 7, cols: 3, sizes: []int{4, 3}},
+		{buttons: 6, cols: 3, sizes: []int{3, 3}},
+		{buttons: 6, cols: 2, sizes: []int{2, 2, 2}},
+		{buttons: 11, cols: 4, sizes: []int{4, 4, 3}},
+		{buttons: 13, cols: 2, sizes: []int{3, 3, 3, 2, 2}},
+		{buttons: 3, cols: 2, sizes: []int{3}},
+		// n <= cols
+		{buttons: 1, cols: 3, sizes: []int{1}},
+		{buttons: 2, cols: 3, sizes: []int{2}},
+		{buttons: 3, cols: 3, sizes: []int{3}},
+		{buttons: 4, cols: 0, sizes: []int{4}},
+		{buttons: 0, cols: 2, sizes: nil},
+	} {
+		t.Run(fmt.Sprintf("%d/%d", tc.buttons, tc.cols), func(t *testing.T) {
+			keyboard := NewDynamicKeyboard[int]()
+			var labels []Button
+			for i := range tc.buttons {
+				label := fmt.Sprintf("b%d", i)
+				labels = append(labels, Button(label))
+				keyboard.AddButton(label, nil, 1)
+			}
+
+			keyboard.BalancedLayout(tc.cols)
+
+			rows := keyboard.Rows()
+			if sizes := rowSizes(rows); !slices.Equal(sizes, tc.sizes) {
+				t.Errorf("expected rows %v, got %v", tc.sizes, sizes)
+			}
+			// the order of the buttons is kept
+			if flat := slices.Concat(rows...); !slices.Equal(flat, labels) {
+				t.Errorf("expected buttons %v, got %v", labels, flat)
+			}
+		})
+	}
+}
+
+func TestAutoLayoutByWidth(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		labels []string
+		width  int
+		rows   [][]string
+	}{
+		{
+			name:   "fill rows",
+			labels: []string{"one", "two", "three", "four"},
+			width:  8,
+			rows:   [][]string{{"one", "two"}, {"three"}, {"four"}},
+		},
+		{
+			// multi-byte labels are measured in characters, not bytes
+			name:   "rune width",
+			labels: []string{"äöü", "ß€", "日本語"},
+			width:  5,
+			rows:   [][]string{{"äöü", "ß€"}, {"日本語"}},
+		},
+		{
+			name:   "label longer than max width",
+			labels: []string{"a", "a very long label", "b", "c"},
+			width:  5,
+			rows:   [][]string{{"a"}, {"a very long label"}, {"b", "c"}},
+		},
+		{
+			name:   "only long labels",
+			labels: []string{"too long", "as well"},
+			width:  3,
+			rows:   [][]string{{"too long"}, {"as well"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keyboard := NewDynamicKeyboard[int]()
+			inline := NewInlineKeyHandler[int]()
+			for _, label := range tc.labels {
+				keyboard.AddButton(label, nil, 0)
+				inline.AddButton(label, label, nil)
+			}
+
+			keyboard.AutoLayoutByWidth(tc.width)
+			var rows [][]string
+			for _, row := range keyboard.Rows() {
+				var labels []string
+				for _, button := range row {
+					labels = append(labels, string(button))
+				}
+				rows = append(rows, labels)
+			}
+			if !slices.EqualFunc(rows, tc.rows, slices.Equal) {
+				t.Errorf("expected rows %q, got %q", tc.rows, rows)
+			}
+
+			inline.AutoLayoutByWidth(tc.width)
+			var inlineRows [][]string
+			for _, row := range inline.Keyboard() {
+				var labels []string
+				for _, button := range row {
+					labels = append(labels, button.Label)
+				}
+				inlineRows = append(inlineRows, labels)
+			}
+			if !slices.EqualFunc(inlineRows, tc.rows, slices.Equal) {
+				t.Errorf("expected inline rows %q, got %q", tc.rows, inlineRows)
+			}
+		})
+	}
+}
+
+// newTestKeyboard creates a keyboard of the rows, using the labels as buttons
+func newTestKeyboard(rows ...[]string) *DynamicKeyboard[int] {
+	keyboard := NewDynamicKeyboard[int]()
+	for _, row := range rows {
+		for _, label := range row {
+			keyboard.AddButton(label, func(bs Session[int]) {}, 0)
+		}
+		keyboard.EndRow()
+	}
+	return keyboard
+}
+
+func TestDynamicKeyboardRemoveButton(t *testing.T) {
+	keyboard := newTestKeyboard([]string{"a", "b"}, []string{"c"}, []string{"d", "e"})
+
+	keyboard.RemoveButton("a")
+	keyboard.RemoveButton("c")
+	keyboard.RemoveButton("unknown")
+
+	if sizes := rowSizes(keyboard.Rows()); !slices.Equal(sizes, []int{1, 2}) {
+		t.Errorf("expected the empty row to be dropped, got rows %v", sizes)
+	}
+	if flat := slices.Concat(keyboard.Rows()...); !slices.Equal(flat, []Button{"b", "d", "e"}) {
+		t.Errorf("unexpected buttons %v", flat)
+	}
+	if keyboard.Handle(nil, "a") {
+		t.Errorf("handler of the removed button was called")
+	}
+}
+
+func TestDynamicKeyboardRows(t *testing.T) {
+	keyboard := NewDynamicKeyboard[int]()
+	keyboard.AddButton("a", nil, 2)
+	keyboard.AddButton("b", nil, 2)
+	// starts a new row after 2 buttons
+	keyboard.AddButton("c", nil, 2)
+	keyboard.EndRow()
+	keyboard.AddButton("d", nil, 2)
+
+	rows := keyboard.Rows()
+	if sizes := rowSizes(rows); !slices.Equal(sizes, []int{2, 1, 1}) {
+		t.Fatalf("unexpected rows %v", sizes)
+	}
+
+	// modifying the returned rows does not modify the keyboard
+	rows[0][0] = "modified"
+	rows[1] = append(rows[1], "appended")
+	if rows := keyboard.Rows(); rows[0][0] != "a" || len(rows[1]) != 1 {
+		t.Errorf("keyboard was modified through its rows: %v", rows)
+	}
+}
+
+func TestDynamicKeyboardInlineKeyboard(t *testing.T) {
+	var pressed Button
+	keyboard := NewDynamicKeyboard[int]()
+	for _, label := range []string{"a", "b", "c"} {
+		keyboard.AddButton(label, func(bs Session[int]) { pressed = Button(label) }, 2)
+	}
+
+	inline := keyboard.InlineKeyboard()
+	expected := InlineKeyboard{
+		{NewInlineButton("a", "a"), NewInlineButton("b", "b")},
+		{NewInlineButton("c", "c")},
+	}
+	if !slices.EqualFunc(inline, expected, slices.Equal) {
+		t.Errorf("expected inline keyboard %v, got %v", expected, inline)
+	}
+
+	if !keyboard.Handle(nil, Button(inline[1][0].Data)) || pressed != "c" {
+		t.Errorf("pressing the inline button was not handled")
+	}
+}
+
+func TestCallbackData(t *testing.T) {
+	parts := []string{"widget", "42", "äöü"}
+	data, err := EncodeCallbackData(parts...)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	if decoded := DecodeCallbackData(data); !slices.Equal(decoded, parts) {
+		t.Errorf("expected %q after round trip, got %q", parts, decoded)
+	}
+
+	if _, err := EncodeCallbackData("a:b"); err == nil {
+		t.Errorf("expected an error for a part containing the separator")
+	}
+
+	// the limit is in bytes: 62 + separator + 1 fits, one more byte does not
+	if _, err := EncodeCallbackData(strings.Repeat("x", 62), "y"); err != nil {
+		t.Errorf("expected %d bytes to fit, got %v", MaxCallbackDataLength, err)
+	}
+	if _, err := EncodeCallbackData(strings.Repeat("x", 62), "ä"); err == nil {
+		t.Errorf("expected an error for %d bytes", MaxCallbackDataLength+1)
+	}
+
+	valid := NewInlineKeyboard(NewInlineRow(NewInlineButton("ok", strings.Repeat("x", MaxCallbackDataLength))))
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected keyboard to be valid, got %v", err)
+	}
+	invalid := NewInlineKeyboard(NewInlineRow(NewInlineButton("too long", strings.Repeat("x", MaxCallbackDataLength+1))))
+	if err := invalid.Validate(); err == nil {
+		t.Errorf("expected an error for oversized data")
+	}
+}
+
+func TestInlineKeyHandlerPages(t *testing.T) {
+	var pressed []string
+	root := func() State[int] {
+		keyHandler := NewInlineKeyHandler[int]()
+		for _, label := range []string{"a", "b", "c", "d", "e"} {
+			keyHandler.AddButton(label, label, func(bs Session[int], query CallbackQuery) {
+				pressed = append(pressed, query.Data())
+			})
+		}
+		return NewStateBuilder[int]().
+			OnActivate(func(bs Session[int]) {
+				bs.SendMessage("pick one", SendMessageInlineKeyboard(keyHandler.PagedKeyboard(0, 2, 2)))
+			}).
+			AddInlineKeyHandler(keyHandler).
+			Build()
+	}
+	mock := newTestMock(t, newTestConfig(root, 1))
+	mock.SendAndWait(1, "hi")
+	messageId := mock.LastMessageID
+
+	mock.PressInline(1, messageId, pageCallbackPrefix+"1")
+
+	var labels []string
+	for _, request := range mock.Requests() {
+		if edit, ok := request.(tgbotapi.EditMessageReplyMarkupConfig); ok && edit.MessageID == messageId {
+			labels = nil
+			for _, row := range edit.ReplyMarkup.InlineKeyboard {
+				for _, button := range row {
+					labels = append(labels, button.Text)
+				}
+			}
+		}
+	}
+	if expected := []string{"c", "d", "◀", "2/3", "▶"}; !slices.Equal(labels, expected) {
+		t.Errorf("expected the second page %q, got %q", expected, labels)
+	}
+
+	mock.PressInline(1, messageId, "d")
+	if !slices.Equal(pressed, []string{"d"}) {
+		t.Errorf("expected the button of the second page to be handled, got %q", pressed)
+	}
+}
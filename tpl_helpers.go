@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -37,10 +38,16 @@ func TplValues(values ...KeyValue) KeyValues {
 	// }
 	// return kv
 }
+
+// RunTemplate executes the template with the values. Duplicate keys are an error,
+// as one of the values would be silently dropped.
 func RunTemplate(tpl string, values ...KeyValue) (string, error) {
 	valueMap := make(map[string]interface{}, len(values))
 
 	for _, value := range values {
+		if _, exists := valueMap[value.Key()]; exists {
+			return "", fmt.Errorf("duplicate template value %q", value.Key())
+		}
 		valueMap[value.Key()] = value.Value()
 	}
 	return RunTemplateMap(tpl, valueMap)
@@ -48,11 +55,16 @@ func RunTemplate(tpl string, values ...KeyValue) (string, error) {
 
 func RunTemplateMap(tpl string, valueMap map[string]any) (string, error) {
 
-	content := template.Must(template.New("").Funcs(templateFuncs).Parse(tpl))
+	content, err := template.New("").Funcs(templateFuncs).Parse(tpl)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template: %w", err)
+	}
 
 	var buf bytes.Buffer
-	err := content.Execute(&buf, valueMap)
-	return buf.String(), err
+	if err := content.Execute(&buf, valueMap); err != nil {
+		return "", fmt.Errorf("error executing template: %w", err)
+	}
+	return buf.String(), nil
 }
 
 var templateFuncs = template.FuncMap{
@@ -63,7 +75,12 @@ var templateFuncs = template.FuncMap{
 	"formatUpdatedRelTime": formatUpdatedRelTime,
 	"formatOnOff":          formatOnOff,
 	"formatTimeHourMinute": formatTimeHourMinute,
+	"formatTimeIn":         formatTimeIn,
+	"formatDuration":       formatDuration,
 	"divider":              func() string { return "========" },
+	"bytes":                formatBytes,
+	"comma":                formatComma,
+	"ordinal":              formatOrdinal,
 }
 
 type kv struct {
@@ -85,24 +102,26 @@ func KV(key string, value interface{}) KeyValue {
 	}
 }
 
-// selectors = "abcdefghijklmnopqrstuvwxyz"
-var selectors = []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "16", "17", "18", "19", "20"}
+// KVf is like KV with a value formatted by fmt.Sprintf
+func KVf(key string, format string, args ...any) KeyValue {
+	return KV(key, fmt.Sprintf(format, args...))
+}
 
+// idxToSelector returns the one-based selector for a zero-based index
 func idxToSelector(idx int) string {
-	if idx >= len(selectors) {
+	if idx < 0 {
 		return "-"
 	}
-	return string(selectors[idx])
+	return strconv.Itoa(idx + 1)
 }
 
-func selectorToIdx(alpha string) int {
-	for idx, selector := range selectors {
-		if selector == alpha {
-			return idx
-		}
+// selectorToIdx returns the zero-based index for a selector, -1 if it is invalid
+func selectorToIdx(selector string) int {
+	idx, err := strconv.Atoi(selector)
+	if err != nil || idx < 1 {
+		return -1
 	}
-	return -1
-	// return strings.Index(selectors, alpha)
+	return idx - 1
 }
 
 var cmdChars = regexp.MustCompile("[^a-zA-Z0-9_]+")
@@ -133,6 +152,24 @@ func formatUpdateTime(updTime time.Time) string {
 	return updTime.Local().Format("Mon, 02 Jan 2006 15:04:05")
 }
 
+// formatDuration formats the duration rounded to seconds, e.g. 26h3m4s
+func formatDuration(dur time.Duration) string {
+	return dur.Round(time.Second).String()
+}
+
+// formatTimeIn formats the time in the given zone, e.g. the session's timeZone.
+// Layout is optional and defaults to the layout of formatUpdateTime.
+func formatTimeIn(updTime time.Time, loc *time.Location, layout ...string) string {
+	if loc == nil {
+		loc = time.Local
+	}
+	format := "Mon, 02 Jan 2006 15:04:05"
+	if len(layout) > 0 {
+		format = layout[0]
+	}
+	return updTime.In(loc).Format(format)
+}
+
 func formatUpdatedRelTime(updTime time.Time) string {
 	return humanize.Time(updTime)
 }
@@ -160,3 +197,58 @@ func formatOnOff(value bool) string {
 	}
 	return "OFF"
 }
+
+// toInt64 converts any integer value passed to a template func
+func toInt64(value any) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	default:
+		return 0, fmt.Errorf("not an integer: %v (%T)", value, value)
+	}
+}
+
+func formatBytes(value any) (string, error) {
+	size, err := toInt64(value)
+	if err != nil {
+		return "", err
+	}
+	if size < 0 {
+		return "-" + humanize.Bytes(uint64(-size)), nil
+	}
+	return humanize.Bytes(uint64(size)), nil
+}
+
+func formatComma(value any) (string, error) {
+	number, err := toInt64(value)
+	if err != nil {
+		return "", err
+	}
+	return humanize.Comma(number), nil
+}
+
+func formatOrdinal(value any) (string, error) {
+	number, err := toInt64(value)
+	if err != nil {
+		return "", err
+	}
+	return humanize.Ordinal(int(number)), nil
+}
@@ -0,0 +1,19 @@
+package botty
+
+import (
+	"testing"
+)
+
+func TestRunTemplate(t *testing.T) {
+	text, err := RunTemplate(`{{.name}} has {{.amount}}`, KV("name", "Anna"), KVf("amount", "%.2f €", 3.5))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text != "Anna has 3.50 €" {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	if _, err := RunTemplate(`{{.name}}`, KV("name", "Anna"), KV("name", "Bob")); err == nil {
+		t.Errorf("expected an error for duplicate keys")
+	}
+}
@@ -10,13 +10,62 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-func UsersList[T any](uStorage UserManager) State[T] {
+type usersListOptions struct {
+	acceptDuration time.Duration
+	inviteMessage  string
+}
+
+type UsersListOption func(opts *usersListOptions)
+
+// UsersListAcceptDuration sets how long new users are accepted after pressing "Add". Defaults to 10 minutes.
+func UsersListAcceptDuration(duration time.Duration) UsersListOption {
+	return func(opts *usersListOptions) {
+		opts.acceptDuration = duration
+	}
+}
+
+// UsersListInviteMessage sets the template of the message sent after pressing "Add".
+// The template gets the values "botName" and "duration".
+func UsersListInviteMessage(template string) UsersListOption {
+	return func(opts *usersListOptions) {
+		opts.inviteMessage = template
+	}
+}
+
+func formatAcceptDuration(duration time.Duration) string {
+	switch {
+	case duration%time.Hour == 0:
+		return pluralize(int(duration/time.Hour), "hour")
+	case duration%time.Minute == 0:
+		return pluralize(int(duration/time.Minute), "minute")
+	}
+	return duration.String()
+}
+
+func pluralize(count int, unit string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
+
+func UsersList[T any](uStorage UserManager, options ...UsersListOption) State[T] {
 	var (
 		Add    Button = "➕ Add"
 		Back   Button = "↩ Back"
 		Delete Button = "❌ Delete"
 	)
 
+	opts := &usersListOptions{
+		acceptDuration: 10 * time.Minute,
+		inviteMessage: `The bot is now set to ACCEPT-mode, allowing new users to join.
+This will be disabled automatically after {{.duration}}.
+Tell you friend to contact bot @{{.botName}} now.`,
+	}
+	for _, option := range options {
+		option(opts)
+	}
+
 	var users []User
 
 	return NewStateBuilder[T]().
@@ -43,20 +92,18 @@ func UsersList[T any](uStorage UserManager) State[T] {
 					NewRow(Add, Delete))))
 		}).
 		OnMessage(func(bs Session[T], message ChatMessage) {
-			botName, err := bs.BotName()
-			if err != nil {
-				bs.Fail("Cannot find bot identity", "error getting bot name: %v", err)
-				return
-			}
-
 			switch Button(message.Text()) {
 			case Back:
 				bs.PopState()
 			case Add:
-				bs.SendTemplateMessage(`The bot is now set to ACCEPT-mode, allowing new users to join.
-This will be disabled automatically after 10 minutes.
-Tell you friend to contact bot @{{.botName}} now.`, TplValues(KV("botName", botName)))
-				bs.AcceptUsers(10 * time.Minute)
+				botName, err := bs.BotName()
+				if err != nil {
+					bs.FailStay("Cannot find bot identity", "error getting bot name: %v", err)
+					return
+				}
+				bs.SendTemplateMessage(opts.inviteMessage, TplValues(KV("botName", botName),
+					KV("duration", formatAcceptDuration(opts.acceptDuration))))
+				bs.AcceptUsers(opts.acceptDuration)
 			case Delete:
 				bs.PushState(SelectToDeleteUser[T](uStorage, users))
 			}
@@ -82,7 +129,7 @@ func SelectToDeleteUser[T any](uStorage UserManager, users []User) State[T] {
 			user := users[idx]
 
 			bs.ReplaceState(PromptState[T](func() {
-				err := uStorage.DeleteUser(user.ID)
+				err := bs.DeleteUser(user.ID)
 				if err != nil {
 					log.Printf("error deleting item %#v: %v", user, err)
 					bs.SendMessage("error deleting user")
@@ -92,6 +139,65 @@ func SelectToDeleteUser[T any](uStorage UserManager, users []User) State[T] {
 	}
 }
 
+// SettingsState lets the user modify the session's settings
+func SettingsState[T any]() State[T] {
+	var (
+		Back   Button = "↩ Back"
+		Pause  Button = "🔕 Pause notifications"
+		Resume Button = "🔔 Resume notifications"
+	)
+
+	return NewStateBuilder[T]().
+		OnActivate(func(bs Session[T]) {
+			settings := bs.Settings()
+			bs.SendTemplateMessage(`Settings
+{{divider}}
+Notifications: {{formatOnOff (not .paused)}}`, TplValues(KV("paused", settings.PauseAllNotifications)),
+				SendMessageWithKeyboard(NewButtonKeyboard(NewRow(Back),
+					NewRow(ConditionalButton(func() bool { return settings.PauseAllNotifications }, Resume, Pause)))))
+		}).
+		OnMessage(func(bs Session[T], message ChatMessage) {
+			switch Button(message.Text()) {
+			case Back:
+				bs.PopState()
+			case Pause:
+				bs.Settings().PauseAllNotifications = true
+				bs.Reenter()
+			case Resume:
+				bs.Settings().PauseAllNotifications = false
+				bs.Reenter()
+			}
+		}).
+		Build()
+}
+
+// StatusState shows the bot's uptime and stats. Opened by /status if Config.EnableStatusCommand is set.
+func StatusState[T any]() State[T] {
+	var Back Button = "↩ Back"
+
+	return NewStateBuilder[T]().
+		OnActivate(func(bs Session[T]) {
+			botName, err := bs.BotName()
+			if err != nil {
+				log.Printf("error getting bot name: %v", err)
+			}
+			stats := bs.BotStats()
+			bs.SendTemplateMessage(`Status of @{{.botName}}
+{{divider}}
+Uptime: {{formatDuration .stats.Uptime}}
+Sessions: {{.stats.Sessions}}
+Messages sent: {{comma .stats.MessagesSent}}
+Messages received: {{comma .stats.MessagesReceived}}`, TplValues(KV("botName", botName), KV("stats", stats)),
+				SendMessageWithKeyboard(NewButtonKeyboard(NewRow(Back))))
+		}).
+		OnMessage(func(bs Session[T], message ChatMessage) {
+			if Button(message.Text()) == Back {
+				bs.PopState()
+			}
+		}).
+		Build()
+}
+
 func findNameForUser(user *tgbotapi.User) string {
 	name := user.UserName
 	if name == "" {
@@ -0,0 +1,21 @@
+package botty
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatAcceptDuration(t *testing.T) {
+	for duration, expected := range map[time.Duration]string{
+		time.Minute:       "1 minute",
+		10 * time.Minute:  "10 minutes",
+		90 * time.Minute:  "90 minutes",
+		time.Hour:         "1 hour",
+		120 * time.Minute: "2 hours",
+		90 * time.Second:  "1m30s",
+	} {
+		if formatted := formatAcceptDuration(duration); formatted != expected {
+			t.Errorf("expected %s for %v, got %s", expected, duration, formatted)
+		}
+	}
+}
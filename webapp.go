@@ -0,0 +1,265 @@
+package botty
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The library's markup types lack web app buttons, so keyboards containing
+// web app buttons are sent using the types below.
+
+type webAppInfo struct {
+	URL string `json:"url"`
+}
+
+type webAppInlineButton struct {
+	tgbotapi.InlineKeyboardButton
+	WebApp *webAppInfo `json:"web_app,omitempty"`
+}
+
+type webAppInlineMarkup struct {
+	InlineKeyboard [][]webAppInlineButton `json:"inline_keyboard"`
+}
+
+type webAppKeyboardButton struct {
+	tgbotapi.KeyboardButton
+	WebApp *webAppInfo `json:"web_app,omitempty"`
+}
+
+type webAppReplyMarkup struct {
+	tgbotapi.ReplyKeyboardMarkup
+	Keyboard [][]webAppKeyboardButton `json:"keyboard"`
+}
+
+// NewInlineButtonWebApp creates a button opening the web app at url
+func NewInlineButtonWebApp(label, url string) InlineButton {
+	return InlineButton{
+		Label:     label,
+		WebAppURL: url,
+	}
+}
+
+// WithWebApp flags the button of the keyboard to open the web app at url when pressed.
+// Data sent by the web app is passed to the config's WebAppDataHandler.
+func WithWebApp(keyboard Keyboard, button Button, url string) Keyboard {
+	return &requestKeyboard{Keyboard: keyboard, webApp: map[Button]string{button: url}}
+}
+
+func (k InlineKeyboard) hasWebApp() bool {
+	for _, row := range k {
+		for _, button := range row {
+			if button.WebAppURL != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inlineMarkup converts the keyboard to the markup to send
+func inlineMarkup(keyboard InlineKeyboard) any {
+	if !keyboard.hasWebApp() {
+		return *convertToMarkup(keyboard)
+	}
+
+	var markup webAppInlineMarkup
+	for _, row := range keyboard {
+		var markupRow []webAppInlineButton
+		for _, button := range row {
+			if button.WebAppURL != "" {
+				markupRow = append(markupRow, webAppInlineButton{
+					InlineKeyboardButton: tgbotapi.InlineKeyboardButton{Text: button.Label},
+					WebApp:               &webAppInfo{URL: button.WebAppURL},
+				})
+				continue
+			}
+			markupRow = append(markupRow, webAppInlineButton{
+				InlineKeyboardButton: tgbotapi.NewInlineKeyboardButtonData(button.Label, button.Data),
+			})
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, markupRow)
+	}
+	return markup
+}
+
+// editMarkup converts the keyboard to the markup of an edit. The library's edit configs cannot contain
+// web app buttons, so for keyboards with web app buttons, no markup is returned and webApp is set.
+// They have to be set using editWebAppKeyboard instead.
+func editMarkup(keyboard InlineKeyboard) (markup *tgbotapi.InlineKeyboardMarkup, webApp bool, err error) {
+	if len(keyboard) == 0 {
+		return nil, false, nil
+	}
+	if err := keyboard.Validate(); err != nil {
+		return nil, false, err
+	}
+	if keyboard.hasWebApp() {
+		return nil, true, nil
+	}
+	return convertToMarkup(keyboard), false, nil
+}
+
+// replyMarkup adds the web app buttons of the requester to the keyboard, if there are any
+func replyMarkup(keyboard tgbotapi.ReplyKeyboardMarkup, requester buttonRequester) any {
+	markup := webAppReplyMarkup{ReplyKeyboardMarkup: keyboard}
+	var hasWebApp bool
+	for _, row := range keyboard.Keyboard {
+		var markupRow []webAppKeyboardButton
+		for _, button := range row {
+			markupButton := webAppKeyboardButton{KeyboardButton: button}
+			if url := requester.webAppURL(Button(button.Text)); url != "" {
+				markupButton.WebApp = &webAppInfo{URL: url}
+				hasWebApp = true
+			}
+			markupRow = append(markupRow, markupButton)
+		}
+		markup.Keyboard = append(markup.Keyboard, markupRow)
+	}
+	if !hasWebApp {
+		return keyboard
+	}
+	return markup
+}
+
+// rawRequester is implemented by tgbotapi.BotAPI
+type rawRequester interface {
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
+// editWebAppKeyboard edits the message's keyboard, and its text if text is set, to a keyboard
+// containing web app buttons. The library's edit configs cannot contain them, so the request is made manually.
+func (bs *session[T]) editWebAppKeyboard(messageId MessageId, keyboard InlineKeyboard, text, parseMode string) error {
+	requester, ok := bs.botApi.(rawRequester)
+	if !ok {
+		return fmt.Errorf("api does not support editing web app buttons")
+	}
+
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", int64(bs.chatId))
+	params.AddNonZero("message_id", int(messageId))
+	endpoint := "editMessageReplyMarkup"
+	if text != "" {
+		endpoint = "editMessageText"
+		params.AddNonEmpty("text", text)
+		params.AddNonEmpty("parse_mode", parseMode)
+	}
+	if err := params.AddInterface("reply_markup", inlineMarkup(keyboard)); err != nil {
+		return err
+	}
+
+	_, err := requester.MakeRequest(endpoint, params)
+	return err
+}
+
+// WebAppData is sent by a web app opened by a reply keyboard button, see WithWebApp
+type WebAppData struct {
+	Data string `json:"data"`
+	// text of the button that opened the web app
+	ButtonText string `json:"button_text"`
+}
+
+// pollUpdates polls the updates like tgbotapi.BotAPI.GetUpdatesChan, but also extracts the
+// web app data of messages, which the library's types lack. The data is stored by update ID.
+func (b *Bot[T]) pollUpdates(ctx context.Context, requester rawRequester, config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	buffer := b.config.UpdateBuffer
+	if buffer <= 0 {
+		buffer = 100
+	}
+	ch := make(chan tgbotapi.Update, buffer)
+
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.shutdown:
+				return
+			default:
+			}
+
+			params := tgbotapi.Params{}
+			params.AddNonZero("offset", config.Offset)
+			params.AddNonZero("limit", config.Limit)
+			params.AddNonZero("timeout", config.Timeout)
+			if err := params.AddInterface("allowed_updates", config.AllowedUpdates); err != nil {
+				log.Printf("error polling updates: %v", err)
+				return
+			}
+
+			updates, webAppData, err := requestUpdates(requester, params)
+			if err != nil {
+				log.Printf("error polling updates, retrying in 3 seconds: %v", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-b.shutdown:
+					return
+				case <-time.After(3 * time.Second):
+				}
+				continue
+			}
+
+			for i, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+				}
+				if webAppData[i] != nil {
+					b.mWebAppData.Lock()
+					b.webAppData[update.UpdateID] = *webAppData[i]
+					b.mWebAppData.Unlock()
+				}
+				select {
+				case ch <- update:
+				case <-ctx.Done():
+					return
+				case <-b.shutdown:
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// requestUpdates gets the updates and the web app data of each update's message, if any
+func requestUpdates(requester rawRequester, params tgbotapi.Params) ([]tgbotapi.Update, []*WebAppData, error) {
+	resp, err := requester.MakeRequest("getUpdates", params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var updates []tgbotapi.Update
+	if err := json.Unmarshal(resp.Result, &updates); err != nil {
+		return nil, nil, fmt.Errorf("error decoding updates: %w", err)
+	}
+	var messages []struct {
+		Message *struct {
+			WebAppData *WebAppData `json:"web_app_data"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(resp.Result, &messages); err != nil {
+		return nil, nil, fmt.Errorf("error decoding web app data: %w", err)
+	}
+
+	webAppData := make([]*WebAppData, len(updates))
+	for i := range min(len(updates), len(messages)) {
+		if messages[i].Message != nil {
+			webAppData[i] = messages[i].Message.WebAppData
+		}
+	}
+	return updates, webAppData, nil
+}
+
+// takeWebAppData returns and forgets the web app data of the update
+func (b *Bot[T]) takeWebAppData(updateID int) (WebAppData, bool) {
+	b.mWebAppData.Lock()
+	defer b.mWebAppData.Unlock()
+	data, ok := b.webAppData[updateID]
+	delete(b.webAppData, updateID)
+	return data, ok
+}
@@ -0,0 +1,114 @@
+package botty
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// updatesRequester returns the same updates on every poll
+type updatesRequester struct {
+	result json.RawMessage
+}
+
+func (r *updatesRequester) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true, Result: r.result}, nil
+}
+
+func TestPollUpdatesStopsOnShutdown(t *testing.T) {
+	b := &Bot[int]{
+		config:     &Config[int]{UpdateBuffer: 1},
+		shutdown:   make(chan struct{}),
+		webAppData: make(map[int]WebAppData),
+	}
+	requester := &updatesRequester{result: json.RawMessage(`[{"update_id": 1}, {"update_id": 2}, {"update_id": 3}]`)}
+	updates := b.pollUpdates(context.Background(), requester, tgbotapi.NewUpdate(0))
+
+	// nobody reads the updates, so polling blocks on the full buffer
+	time.Sleep(10 * time.Millisecond)
+	b.shutdownBot()
+	time.Sleep(50 * time.Millisecond)
+
+	// only the buffered update is left, the blocked ones are dropped
+	var received int
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				if received > 1 {
+					t.Errorf("expected polling to stop on shutdown, received %d updates", received)
+				}
+				return
+			}
+			received++
+		case <-timeout:
+			t.Fatalf("polling did not stop on shutdown")
+		}
+	}
+}
+
+func TestWebAppDataOfDroppedUpdateIsForgotten(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState, 1))
+	bot := mock.bot
+
+	bot.mWebAppData.Lock()
+	bot.webAppData[42] = WebAppData{Data: "data"}
+	bot.mWebAppData.Unlock()
+
+	// an update without sender is dropped
+	mock.sendUpdate(tgbotapi.Update{UpdateID: 42})
+
+	bot.mWebAppData.Lock()
+	defer bot.mWebAppData.Unlock()
+	if len(bot.webAppData) != 0 {
+		t.Errorf("web app data of the dropped update was not forgotten")
+	}
+}
+
+// rawRecordingApi records the raw requests made by the session
+type rawRecordingApi struct {
+	TGApi
+	requests []tgbotapi.Params
+}
+
+func (r *rawRecordingApi) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	params["endpoint"] = endpoint
+	r.requests = append(r.requests, params)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func TestUpdateMessageMediaWithWebAppKeyboard(t *testing.T) {
+	mock := newTestMock(t, newTestConfig(newEchoState, 1))
+	mock.SendAndWait(1, "hi")
+
+	keyboard := InlineKeyboard{{NewInlineButtonWebApp("open", "https://example.com"), NewInlineButton("next", "next")}}
+	var raw *rawRecordingApi
+	done := make(chan struct{})
+	mock.bot.Do(1, func(bs Session[int]) {
+		defer close(done)
+		session := bs.(*session[int])
+		raw = &rawRecordingApi{TGApi: session.botApi}
+		session.botApi = raw
+		bs.UpdateMessageMedia(42, MediaItem{Type: MediaPhoto, Source: FileFromID("photo")}, keyboard)
+	})
+	<-done
+
+	var edits []tgbotapi.EditMessageMediaConfig
+	for _, request := range mock.Requests() {
+		if edit, ok := request.(tgbotapi.EditMessageMediaConfig); ok {
+			edits = append(edits, edit)
+		}
+	}
+	if len(edits) != 1 || edits[0].ReplyMarkup != nil {
+		t.Fatalf("expected a media edit without markup, got %v", edits)
+	}
+	if len(raw.requests) != 1 || raw.requests[0]["endpoint"] != "editMessageReplyMarkup" ||
+		!strings.Contains(raw.requests[0]["reply_markup"], `"web_app":{"url":"https://example.com"}`) {
+		t.Errorf("expected the web app keyboard to be set separately, got %v", raw.requests)
+	}
+}